@@ -1,96 +1,35 @@
 package auth
 
 import (
-	"time"
+	"context"
+	"net/http"
+	"strconv"
 
-	"github.com/lxfontes/jarbas/chat"
 	"github.com/lxfontes/jarbas/store"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
 )
 
-const (
-	githubAuthCollection = "github_auth_data"
-)
-
-type githubAuth struct {
-}
-
-type GithubAuthData struct {
-	UserID      string `json:"user_id"`
-	GithubLogin string `json:"github_login"`
-	GithubToken string `json:"github_token"`
-	LoginCount  int    `json:"login_count"`
-}
-
-var _ store.Storable = &GithubAuthData{}
-var _ chat.ChatExternalUser = &GithubAuthData{}
-
-func (gd *GithubAuthData) ID() string {
-	return gd.GithubLogin
-}
-
-func (gd *GithubAuthData) Name() string {
-	return gd.GithubLogin
-}
-
-func (gd *GithubAuthData) Site() string {
-	return "github"
-}
-
-func (gd *GithubAuthData) Token() string {
-	return gd.GithubToken
-}
-
-func (gd *GithubAuthData) StoreID() string {
-	return gd.UserID
-}
+const githubUserURL = "https://api.github.com/user"
 
-func (gd *GithubAuthData) StoreExpires() time.Time {
-	return time.Time{}
+func newGithubAuth(clientID string, clientSecret string, publicURL string, store store.Store, opts ...oauth2Opt) *OAuth2AuthHandler {
+	return NewOAuth2AuthHandler("github", &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint:     endpoints.GitHub,
+		RedirectURL:  publicURL + oauth2CallbackPath("github"),
+	}, store, githubUserInfo, opts...)
 }
 
-func (gd *GithubAuthData) Validate() error {
-	if gd.LoginCount > 5 {
-		return chat.ErrUserAuthNeeded
-	}
-
-	// check with github if this token is still valid
-	return nil
-}
-
-var _ chat.ChatAuthHandler = &githubAuth{}
-
-func (gl *githubAuth) Name() string {
-	return "github"
-}
-
-func (gl *githubAuth) Authorize(user *chat.ChatUser, role string) (chat.ChatExternalUser, error) {
-	authData := &GithubAuthData{}
-	userStore := user.Bot().Store()
-	err := userStore.Namespace(githubAuthCollection).FindByID(user.ID(), authData)
-
-	if err != nil && err != store.ErrItemNotFound {
-		return nil, err
-	}
-
-	if err == store.ErrItemNotFound {
-		// onboard
-		authData.UserID = user.ID()
-	}
-
-	if err = authData.Validate(); err != nil && err != chat.ErrUserAuthNeeded {
-		return nil, err
-	}
-
-	if err == chat.ErrUserAuthNeeded {
-		// delete local token, tell user to go through auth again
-		return nil, err
-	}
+func githubUserInfo(ctx context.Context, client *http.Client) (string, string, error) {
+	user := struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}{}
 
-	//  update login counter
-	authData.LoginCount++
-	if err = userStore.Namespace(githubAuthCollection).Save(authData); err != nil {
-		return nil, err
+	if err := getJSON(client, githubUserURL, &user); err != nil {
+		return "", "", err
 	}
 
-	return authData, nil
+	return strconv.FormatInt(user.ID, 10), user.Login, nil
 }
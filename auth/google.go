@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/lxfontes/jarbas/store"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const googleUserURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+
+func newGoogleAuth(clientID string, clientSecret string, publicURL string, store store.Store, opts ...oauth2Opt) *OAuth2AuthHandler {
+	return NewOAuth2AuthHandler("google", &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint:     endpoints.Google,
+		RedirectURL:  publicURL + oauth2CallbackPath("google"),
+		Scopes:       []string{"email"},
+	}, store, googleUserInfo, opts...)
+}
+
+func googleUserInfo(ctx context.Context, client *http.Client) (string, string, error) {
+	user := struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}{}
+
+	if err := getJSON(client, googleUserURL, &user); err != nil {
+		return "", "", err
+	}
+
+	return user.ID, user.Email, nil
+}
@@ -1,9 +1,40 @@
 package auth
 
-import "github.com/lxfontes/jarbas/chat"
+import (
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/lxfontes/jarbas/chat"
+)
+
+// RegisterHandlers adds login commands for configured providers, their oauth2 callbacks go on mux
+func RegisterHandlers(bot *chat.ChatBot, mux *http.ServeMux) error {
+	publicURL := os.Getenv("PUBLIC_URL")
+
+	var opts []oauth2Opt
+	if ttl := os.Getenv("AUTH_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, WithAuthTTL(d))
+	}
+
+	for _, handler := range []*OAuth2AuthHandler{
+		newGithubAuth(os.Getenv("GITHUB_CLIENT_ID"), os.Getenv("GITHUB_CLIENT_SECRET"), publicURL, bot.Store(), opts...),
+		newGoogleAuth(os.Getenv("GOOGLE_CLIENT_ID"), os.Getenv("GOOGLE_CLIENT_SECRET"), publicURL, bot.Store(), opts...),
+	} {
+		// login needs an oauth app registered with the provider
+		if handler.config.ClientID == "" {
+			bot.Logger().WithField("site", handler.Name()).Warning("client id not set, login disabled")
+			continue
+		}
+
+		if err := handler.Register(bot, mux); err != nil {
+			return err
+		}
+	}
 
-func RegisterHandlers(bot *chat.ChatBot) error {
-	github := &githubAuth{}
-	bot.AddAuthHandler(github)
 	return nil
 }
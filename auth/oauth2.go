@@ -0,0 +1,405 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lxfontes/jarbas/chat"
+	"github.com/lxfontes/jarbas/store"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// how long a login link stays valid
+	oauth2StateTTL = 10 * time.Minute
+
+	// users are asked to link their account again after this long
+	defaultAuthTTL = 30 * 24 * time.Hour
+
+	// Authorize gives up on the provider after this long
+	defaultValidateTimeout = 10 * time.Second
+)
+
+var ErrUnknownState = errors.New("unknown or expired oauth2 state")
+
+// OAuth2UserInfo looks up the provider account behind an authenticated client.
+// Return chat.ErrUserAuthNeeded when the provider rejects the token.
+type OAuth2UserInfo func(ctx context.Context, client *http.Client) (id string, login string, err error)
+
+// OAuth2AuthData is what gets persisted per slack user and site
+type OAuth2AuthData struct {
+	UserID     string        `json:"user_id"`
+	SiteName   string        `json:"site"`
+	ExternalID string        `json:"external_id"`
+	Login      string        `json:"login"`
+	OAuthToken *oauth2.Token `json:"token"`
+	LoginCount int           `json:"login_count"`
+	Expires    time.Time     `json:"expires"`
+}
+
+var _ store.Storable = &OAuth2AuthData{}
+var _ chat.ChatExternalUser = &OAuth2AuthData{}
+
+func (ad *OAuth2AuthData) ID() string {
+	return ad.ExternalID
+}
+
+func (ad *OAuth2AuthData) Name() string {
+	return ad.Login
+}
+
+func (ad *OAuth2AuthData) Site() string {
+	return ad.SiteName
+}
+
+func (ad *OAuth2AuthData) Token() string {
+	if ad.OAuthToken == nil {
+		return ""
+	}
+
+	return ad.OAuthToken.AccessToken
+}
+
+func (ad *OAuth2AuthData) StoreID() string {
+	return ad.UserID
+}
+
+func (ad *OAuth2AuthData) StoreExpires() time.Time {
+	return ad.Expires
+}
+
+func (ad *OAuth2AuthData) expired() bool {
+	return !ad.Expires.IsZero() && time.Now().After(ad.Expires)
+}
+
+// oauth2State ties an oauth2 state parameter to the slack user who asked for it
+type oauth2State struct {
+	State   string    `json:"state"`
+	UserID  string    `json:"user_id"`
+	Expires time.Time `json:"expires"`
+}
+
+var _ store.Storable = &oauth2State{}
+
+func (st *oauth2State) StoreID() string {
+	return st.State
+}
+
+func (st *oauth2State) StoreExpires() time.Time {
+	return st.Expires
+}
+
+// OAuth2AuthHandler onboards users through the oauth2 authorization code flow.
+// Users ask for a link with '<name> login', the provider redirects back to CallbackPath.
+type OAuth2AuthHandler struct {
+	name     string
+	config   *oauth2.Config
+	store    store.Store
+	userInfo OAuth2UserInfo
+	ttl      time.Duration
+	timeout  time.Duration
+	bot      *chat.ChatBot
+}
+
+type oauth2Opt func(*OAuth2AuthHandler)
+
+// WithAuthTTL sets how long a linked account is trusted, 0 means forever
+func WithAuthTTL(ttl time.Duration) oauth2Opt {
+	return func(oh *OAuth2AuthHandler) {
+		oh.ttl = ttl
+	}
+}
+
+// WithValidateTimeout bounds the token refresh & account lookup done by Authorize
+func WithValidateTimeout(timeout time.Duration) oauth2Opt {
+	return func(oh *OAuth2AuthHandler) {
+		oh.timeout = timeout
+	}
+}
+
+var _ chat.ChatAuthHandler = &OAuth2AuthHandler{}
+var _ chat.ChatDeauthorizer = &OAuth2AuthHandler{}
+var _ chat.ChatMessageHandler = &OAuth2AuthHandler{}
+var _ http.Handler = &OAuth2AuthHandler{}
+
+func NewOAuth2AuthHandler(name string, cfg *oauth2.Config, store store.Store, userInfo OAuth2UserInfo, opts ...oauth2Opt) *OAuth2AuthHandler {
+	oh := &OAuth2AuthHandler{
+		name:     name,
+		config:   cfg,
+		store:    store,
+		userInfo: userInfo,
+		ttl:      defaultAuthTTL,
+		timeout:  defaultValidateTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(oh)
+	}
+
+	return oh
+}
+
+func (oh *OAuth2AuthHandler) Name() string {
+	return oh.name
+}
+
+// CallbackPath is where the provider should redirect to, relative to the public url
+func (oh *OAuth2AuthHandler) CallbackPath() string {
+	return oauth2CallbackPath(oh.name)
+}
+
+func oauth2CallbackPath(name string) string {
+	return "/auth/" + name + "/callback"
+}
+
+// Register wires the auth handler, login command and the http callback on mux
+func (oh *OAuth2AuthHandler) Register(bot *chat.ChatBot, mux *http.ServeMux) error {
+	oh.bot = bot
+
+	if err := bot.AddAuthHandler(oh); err != nil {
+		return err
+	}
+
+	if err := bot.AddMessageHandler(oh.name+" login", oh,
+		chat.WithDescription(fmt.Sprintf("links your %s account", oh.name)),
+	); err != nil {
+		return err
+	}
+
+	if err := bot.AddMessageHandler(oh.name+" logout", &oauth2Logout{oh},
+		chat.WithDescription(fmt.Sprintf("unlinks your %s account", oh.name)),
+	); err != nil {
+		return err
+	}
+
+	mux.Handle(oh.CallbackPath(), oh)
+	return nil
+}
+
+func (oh *OAuth2AuthHandler) authData() store.Namespace {
+	return oh.store.Namespace(oh.name + "_auth_data")
+}
+
+func (oh *OAuth2AuthHandler) states() store.Namespace {
+	return oh.store.Namespace(oh.name + "_auth_state")
+}
+
+func (oh *OAuth2AuthHandler) Authorize(user *chat.ChatUser, role string) (chat.ChatExternalUser, error) {
+	authData := &OAuth2AuthData{}
+	err := oh.authData().FindByID(user.ID(), authData)
+
+	if err == store.ErrItemNotFound {
+		// onboarding happens through '<name> login'
+		return nil, chat.ErrUserAuthNeeded
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// stores purge expired items on their own, but don't count on it
+	if authData.expired() {
+		oh.authData().Delete(user.ID())
+		return nil, chat.ErrUserAuthNeeded
+	}
+
+	// a hung provider must not hang the message handler
+	ctx, cancel := context.WithTimeout(context.Background(), oh.timeout)
+	defer cancel()
+
+	if err = oh.validate(ctx, authData); err == chat.ErrUserAuthNeeded {
+		// delete local token, tell user to go through auth again
+		oh.authData().Delete(user.ID())
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	authData.LoginCount++
+	if err = oh.authData().Save(authData); err != nil {
+		return nil, err
+	}
+
+	return authData, nil
+}
+
+// Deauthorize drops the stored token, the user has to login again
+func (oh *OAuth2AuthHandler) Deauthorize(user *chat.ChatUser) error {
+	return oh.authData().Delete(user.ID())
+}
+
+// validate refreshes the token if needed and confirms the account with the provider
+func (oh *OAuth2AuthHandler) validate(ctx context.Context, authData *OAuth2AuthData) error {
+	if authData.OAuthToken == nil {
+		return chat.ErrUserAuthNeeded
+	}
+
+	if !authData.OAuthToken.Valid() && authData.OAuthToken.RefreshToken == "" {
+		return chat.ErrUserAuthNeeded
+	}
+
+	token, err := oh.config.TokenSource(ctx, authData.OAuthToken).Token()
+	if _, ok := err.(*oauth2.RetrieveError); ok {
+		// refresh token got revoked
+		return chat.ErrUserAuthNeeded
+	}
+
+	if err != nil {
+		return err
+	}
+
+	id, login, err := oh.userInfo(ctx, oauth2.NewClient(ctx, oauth2.StaticTokenSource(token)))
+	if err != nil {
+		return err
+	}
+
+	authData.OAuthToken = token
+	authData.ExternalID = id
+	authData.Login = login
+	return nil
+}
+
+// OnChatMessage DMs the user a login link
+func (oh *OAuth2AuthHandler) OnChatMessage(msg *chat.ChatMessage) error {
+	state, err := newState()
+	if err != nil {
+		return err
+	}
+
+	authState := &oauth2State{
+		State:   state,
+		UserID:  msg.User.ID(),
+		Expires: time.Now().Add(oauth2StateTTL),
+	}
+
+	if err = oh.states().Save(authState); err != nil {
+		return err
+	}
+
+	_, err = msg.ReplyPrivately("link your %s account: %s", oh.name, oh.config.AuthCodeURL(state))
+	return err
+}
+
+type oauth2Logout struct {
+	handler *OAuth2AuthHandler
+}
+
+var _ chat.ChatMessageHandler = &oauth2Logout{}
+
+func (ol *oauth2Logout) Name() string {
+	return ol.handler.name + "_logout"
+}
+
+func (ol *oauth2Logout) OnChatMessage(msg *chat.ChatMessage) error {
+	if err := msg.Deauthorize(ol.handler.name); err != nil {
+		return err
+	}
+
+	_, err := msg.ReplyPrivately("unlinked your %s account", ol.handler.name)
+	return err
+}
+
+// ServeHTTP handles the oauth2 callback from the provider
+func (oh *OAuth2AuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authData, err := oh.exchange(r.Context(), r.FormValue("state"), r.FormValue("code"))
+	if err != nil {
+		if oh.bot != nil {
+			oh.bot.Logger().WithField("site", oh.name).WithError(err).Error("oauth2 exchange failed")
+		}
+		http.Error(w, fmt.Sprintf("could not link %s account, please try again", oh.name), http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, "linked %s account %s, you can close this window", oh.name, authData.Login)
+
+	if oh.bot != nil {
+		oh.bot.SendPrivately(oh.bot.User(authData.UserID), "", "linked %s account `%s`", oh.name, authData.Login)
+	}
+}
+
+func (oh *OAuth2AuthHandler) exchange(ctx context.Context, state string, code string) (*OAuth2AuthData, error) {
+	authState := &oauth2State{}
+	err := oh.states().FindByID(state, authState)
+	if err == store.ErrItemNotFound {
+		return nil, ErrUnknownState
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// states are single use
+	if err = oh.states().Delete(state); err != nil {
+		return nil, err
+	}
+
+	token, err := oh.config.Exchange(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	id, login, err := oh.userInfo(ctx, oh.config.Client(ctx, token))
+	if err != nil {
+		return nil, err
+	}
+
+	authData := &OAuth2AuthData{}
+	err = oh.authData().FindByID(authState.UserID, authData)
+	if err != nil && err != store.ErrItemNotFound {
+		return nil, err
+	}
+
+	authData.UserID = authState.UserID
+	authData.SiteName = oh.name
+	authData.ExternalID = id
+	authData.Login = login
+	authData.OAuthToken = token
+	authData.Expires = time.Time{}
+
+	if oh.ttl > 0 {
+		authData.Expires = time.Now().Add(oh.ttl)
+	}
+
+	if err = oh.authData().Save(authData); err != nil {
+		return nil, err
+	}
+
+	return authData, nil
+}
+
+// getJSON decodes a provider api response, mapping 401 to chat.ErrUserAuthNeeded
+func getJSON(client *http.Client, url string, out interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		// revoked or expired upstream
+		return chat.ErrUserAuthNeeded
+	default:
+		return fmt.Errorf("%s: unexpected status %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func newState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lxfontes/jarbas/chat"
+	"github.com/lxfontes/jarbas/store"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func testOAuth2Handler(t *testing.T, opts ...oauth2Opt) (*OAuth2AuthHandler, func()) {
+	provider := http.NewServeMux()
+	provider.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good-code" {
+			http.Error(w, `{"error":"bad_verification_code"}`, http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"secret","token_type":"bearer"}`)
+	})
+	server := httptest.NewServer(provider)
+
+	cfg := &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  server.URL + "/authorize",
+			TokenURL: server.URL + "/token",
+		},
+	}
+
+	userInfo := func(ctx context.Context, client *http.Client) (string, string, error) {
+		return "42", "octocat", nil
+	}
+
+	return NewOAuth2AuthHandler("test", cfg, store.NewMemoryStore(), userInfo, opts...), server.Close
+}
+
+func saveState(t *testing.T, handler *OAuth2AuthHandler, state string) {
+	err := handler.states().Save(&oauth2State{
+		State:   state,
+		UserID:  "U123",
+		Expires: time.Now().Add(time.Minute),
+	})
+	assert.Nil(t, err)
+}
+
+func TestOAuth2Callback(t *testing.T) {
+	handler, done := testOAuth2Handler(t)
+	defer done()
+
+	saveState(t, handler, "abc")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/auth/test/callback?state=abc&code=good-code", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	authData := &OAuth2AuthData{}
+	assert.Nil(t, handler.authData().FindByID("U123", authData))
+	assert.Equal(t, "test", authData.Site())
+	assert.Equal(t, "42", authData.ID())
+	assert.Equal(t, "octocat", authData.Name())
+	assert.Equal(t, "secret", authData.Token())
+	assert.WithinDuration(t, time.Now().Add(defaultAuthTTL), authData.StoreExpires(), time.Minute)
+
+	// states are single use
+	exists, err := handler.states().Exists("abc")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestOAuth2CallbackRejects(t *testing.T) {
+	handler, done := testOAuth2Handler(t)
+	defer done()
+
+	_, err := handler.exchange(context.Background(), "unknown", "good-code")
+	assert.Equal(t, ErrUnknownState, err)
+
+	saveState(t, handler, "abc")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/auth/test/callback?state=abc&code=bad-code", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	exists, err := handler.authData().Exists("U123")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestOAuth2AuthorizeExpired(t *testing.T) {
+	handler, done := testOAuth2Handler(t, WithAuthTTL(time.Millisecond))
+	defer done()
+
+	saveState(t, handler, "abc")
+	_, err := handler.exchange(context.Background(), "abc", "good-code")
+	assert.Nil(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+
+	bot, err := chat.NewChatBot("")
+	assert.Nil(t, err)
+
+	_, err = handler.Authorize(bot.User("U123"), "")
+	assert.Equal(t, chat.ErrUserAuthNeeded, err)
+}
+
+func TestOAuth2AuthorizeTimeout(t *testing.T) {
+	handler, done := testOAuth2Handler(t, WithValidateTimeout(20*time.Millisecond))
+	defer done()
+
+	saveState(t, handler, "abc")
+	_, err := handler.exchange(context.Background(), "abc", "good-code")
+	assert.Nil(t, err)
+
+	// the provider never answers
+	handler.userInfo = func(ctx context.Context, client *http.Client) (string, string, error) {
+		<-ctx.Done()
+		return "", "", ctx.Err()
+	}
+
+	bot, err := chat.NewChatBot("")
+	assert.Nil(t, err)
+
+	start := time.Now()
+	_, err = handler.Authorize(bot.User("U123"), "")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	// a slow provider isn't a reason to drop the account
+	exists, err := handler.authData().Exists("U123")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
+
+func TestOAuth2Deauthorize(t *testing.T) {
+	handler, done := testOAuth2Handler(t)
+	defer done()
+
+	saveState(t, handler, "abc")
+	_, err := handler.exchange(context.Background(), "abc", "good-code")
+	assert.Nil(t, err)
+
+	bot, err := chat.NewChatBot("")
+	assert.Nil(t, err)
+
+	assert.Nil(t, handler.Deauthorize(bot.User("U123")))
+
+	exists, err := handler.authData().Exists("U123")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestOAuth2Register(t *testing.T) {
+	handler, done := testOAuth2Handler(t)
+	defer done()
+
+	bot, err := chat.NewChatBot("")
+	assert.Nil(t, err)
+
+	mux := http.NewServeMux()
+	assert.Nil(t, handler.Register(bot, mux))
+	assert.Contains(t, bot.Patterns(), "test login")
+	assert.Contains(t, bot.Patterns(), "test logout")
+
+	saveState(t, handler, "abc")
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/auth/test/callback?state=abc&code=good-code", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// nothing leaks onto the global mux
+	_, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", "/auth/test/callback", nil))
+	assert.Equal(t, "", pattern)
+}
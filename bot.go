@@ -1,21 +1,58 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/lxfontes/jarbas/auth"
 	"github.com/lxfontes/jarbas/chat"
 	"github.com/lxfontes/jarbas/commands"
+	"github.com/lxfontes/jarbas/metrics"
 	"github.com/lxfontes/jarbas/reactions"
+	"github.com/lxfontes/jarbas/store"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type pluginInitializer func(*chat.ChatBot) error
 
+// newStore picks a persistent store from the environment, defaults to memory
+func newStore() (store.Store, error) {
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		return store.NewRedisStore(store.WithAddr(addr), store.WithPassword(os.Getenv("REDIS_PASSWORD")))
+	}
+
+	if path := os.Getenv("BOLT_PATH"); path != "" {
+		return store.NewBoltStore(path)
+	}
+
+	return store.NewMemoryStore(), nil
+}
+
 func main() {
-	b, _ := chat.NewChatBot(os.Getenv("SLACK_TOKEN"))
+	s, err := newStore()
+	if err != nil {
+		panic(err)
+	}
+
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg)
+	if err != nil {
+		panic(err)
+	}
+
+	b, _ := chat.NewChatBot(os.Getenv("SLACK_TOKEN"),
+		chat.WithStore(metrics.InstrumentStore(s, m)),
+		chat.WithObserver(m),
+	)
+
+	// oauth callbacks and other web endpoints
+	mux := http.NewServeMux()
 
 	for _, initializer := range []pluginInitializer{
-		auth.RegisterHandlers,
+		func(b *chat.ChatBot) error { return auth.RegisterHandlers(b, mux) },
 		commands.RegisterHandlers,
 		reactions.RegisterHandlers,
 	} {
@@ -24,5 +61,40 @@ func main() {
 		}
 	}
 
+	if addr := os.Getenv("HTTP_ADDR"); addr != "" {
+		mux.Handle("/healthz", b.HealthHandler(5*time.Second))
+		mux.Handle("/metrics", metrics.Handler(reg))
+
+		// redis can go away under us, report it separately from slack
+		if pinger, ok := s.(interface{ Ping() error }); ok {
+			mux.HandleFunc("/healthz/store", func(w http.ResponseWriter, r *http.Request) {
+				if err := pinger.Ping(); err != nil {
+					http.Error(w, err.Error(), http.StatusServiceUnavailable)
+					return
+				}
+
+				fmt.Fprintln(w, "ok")
+			})
+		}
+
+		if secret := os.Getenv("SLACK_SIGNING_SECRET"); secret != "" {
+			mux.Handle("/slack/interactions", b.InteractionHandler(secret))
+			mux.Handle("/slack/commands", b.SlashCommandHandler(secret))
+		}
+
+		go func() {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				b.Logger().WithError(err).Error("http server stopped")
+			}
+		}()
+	}
+
 	b.Serve()
+
+	// flush snapshots, release pooled connections
+	if closer, ok := s.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			b.Logger().WithError(err).Error("could not close store")
+		}
+	}
 }
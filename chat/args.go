@@ -1,13 +1,48 @@
 package chat
 
 import (
-	"fmt"
+	"errors"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ChatArgs map[string]string
 
+// argKind is what typed args must parse as, the zero value accepts anything
+type argKind int
+
+const (
+	argString argKind = iota
+	argInt
+	argFloat
+	argDuration
+)
+
+func (ak argKind) validate(value string) error {
+	var err error
+
+	switch ak {
+	case argInt:
+		_, err = strconv.Atoi(value)
+		if err != nil {
+			err = errors.New("expected an integer")
+		}
+	case argFloat:
+		_, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			err = errors.New("expected a number")
+		}
+	case argDuration:
+		_, err = time.ParseDuration(value)
+		if err != nil {
+			err = errors.New("expected a duration, ex: 30s")
+		}
+	}
+
+	return err
+}
+
 func (ca ChatArgs) String(parameter string) (string, bool) {
 	s, ok := ca[parameter]
 	return s, ok
@@ -22,20 +57,53 @@ func (ca ChatArgs) Keys() []string {
 	return ret
 }
 
+// Int is only ok when parameter is present and parses
 func (ca ChatArgs) Int(parameter string) (int, bool) {
 	v, ok := ca.String(parameter)
+	if !ok {
+		return 0, false
+	}
+
 	i, err := strconv.Atoi(v)
 	if err != nil {
-		fmt.Println("seriously?", err)
 		return 0, false
 	}
 
-	return i, ok
+	return i, true
+}
+
+func (ca ChatArgs) Float(parameter string) (float64, bool) {
+	v, ok := ca.String(parameter)
+	if !ok {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return f, true
+}
+
+func (ca ChatArgs) Duration(parameter string) (time.Duration, bool) {
+	v, ok := ca.String(parameter)
+	if !ok {
+		return 0, false
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, false
+	}
+
+	return d, true
 }
 
 func (ca ChatArgs) Bool(parameter string) (bool, bool) {
 	v, ok := ca.String(parameter)
-	if strings.TrimSpace(strings.ToLower(v)) == "true" {
+	switch strings.TrimSpace(strings.ToLower(v)) {
+	case "true", "1", "yes", "on":
 		return true, ok
 	}
 
@@ -0,0 +1,285 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedArgs(t *testing.T) {
+	args := ChatArgs{
+		"timeout": "30s",
+		"ratio":   "0.25",
+		"garbage": "lol",
+	}
+
+	d, ok := args.Duration("timeout")
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+
+	f, ok := args.Float("ratio")
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, f)
+
+	_, ok = args.Duration("garbage")
+	assert.False(t, ok)
+	_, ok = args.Float("garbage")
+	assert.False(t, ok)
+
+	_, ok = args.Duration("missing")
+	assert.False(t, ok)
+	_, ok = args.Float("missing")
+	assert.False(t, ok)
+}
+
+func TestIntArg(t *testing.T) {
+	args := ChatArgs{
+		"count":    "42",
+		"negative": "-5",
+		"garbage":  "lol",
+	}
+
+	i, ok := args.Int("count")
+	assert.True(t, ok)
+	assert.Equal(t, 42, i)
+
+	i, ok = args.Int("negative")
+	assert.True(t, ok)
+	assert.Equal(t, -5, i)
+
+	i, ok = args.Int("garbage")
+	assert.False(t, ok)
+	assert.Equal(t, 0, i)
+
+	i, ok = args.Int("missing")
+	assert.False(t, ok)
+	assert.Equal(t, 0, i)
+}
+
+func TestBoolArg(t *testing.T) {
+	args := ChatArgs{}
+	for _, v := range []string{"true", "TRUE", "1", "yes", "On"} {
+		args["flag"] = v
+		b, ok := args.Bool("flag")
+		assert.True(t, ok, v)
+		assert.True(t, b, v)
+	}
+
+	for _, v := range []string{"false", "0", "no", "off", "lol"} {
+		args["flag"] = v
+		b, _ := args.Bool("flag")
+		assert.False(t, b, v)
+	}
+}
+
+func TestFlagArg(t *testing.T) {
+	ca := &chatAction{}
+	WithFlagArg("force", "skip checks")(ca)
+	WithRequiredArg("env", "target environment")(ca)
+
+	msg := &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "--force prod",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+
+	force, _ := msg.Args.Bool("force")
+	assert.True(t, force)
+	env, _ := msg.StringArg("env")
+	assert.Equal(t, "prod", env)
+
+	msg = &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "prod",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+
+	force, ok := msg.Args.Bool("force")
+	assert.True(t, ok)
+	assert.False(t, force)
+
+	msg = &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "prod --nope",
+		Args:    ChatArgs{},
+	}
+	assert.NotNil(t, parseArguments(ca.args, msg))
+
+	// quoted or escaped dashes are plain values
+	for _, raw := range []string{`"--force"`, `'--force'`, `\--force`, `env="--force"`} {
+		msg = &ChatMessage{
+			Match:   "deploy",
+			RawArgs: raw,
+			Args:    ChatArgs{},
+		}
+		assert.Nil(t, parseArguments(ca.args, msg), raw)
+
+		force, _ = msg.Args.Bool("force")
+		assert.False(t, force, raw)
+		env, _ = msg.StringArg("env")
+		assert.Equal(t, "--force", env, raw)
+	}
+}
+
+func TestChoiceArg(t *testing.T) {
+	ca := &chatAction{}
+	WithChoiceArg("env", []string{"prod", "staging"}, "target environment")(ca)
+
+	msg := &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "staging",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+	assert.Equal(t, "staging", msg.Args["env"])
+
+	msg = &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "env=qa",
+		Args:    ChatArgs{},
+	}
+	err := parseArguments(ca.args, msg)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, `invalid value "qa" for argument "env", must be one of: prod, staging`, err.Error())
+	}
+
+	assert.Equal(t, "`env` (one of `prod`, `staging`) target environment", ca.args[0].help())
+}
+
+func TestTypedDefaults(t *testing.T) {
+	ca := &chatAction{}
+	WithOptionalIntArg("count", 3, "how many")(ca)
+	WithOptionalFloatArg("ratio", 0.5, "canary ratio")(ca)
+	WithOptionalDurationArg("timeout", time.Minute, "give up after")(ca)
+
+	msg := &ChatMessage{
+		Match: "deploy",
+		Args:  ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+
+	i, ok := msg.IntArg("count")
+	assert.True(t, ok)
+	assert.Equal(t, 3, i)
+
+	f, ok := msg.FloatArg("ratio")
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, f)
+
+	d, ok := msg.DurationArg("timeout")
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+
+	msg = &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "count=5 timeout=10s",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+
+	i, _ = msg.IntArg("count")
+	assert.Equal(t, 5, i)
+	d, _ = msg.DurationArg("timeout")
+	assert.Equal(t, 10*time.Second, d)
+
+	msg = &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "lots",
+		Args:    ChatArgs{},
+	}
+	err := parseArguments(ca.args, msg)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, `invalid value "lots" for argument "count": expected an integer`, err.Error())
+	}
+}
+
+func TestListArg(t *testing.T) {
+	ca := &chatAction{}
+	WithRequiredArg("channel", "where to notify")(ca)
+	WithListArg("user", "who to notify")(ca)
+
+	msg := &ChatMessage{
+		Match:   "notify",
+		RawArgs: "channel=general user=alice user=bob user=carol",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+
+	users, ok := msg.ListArg("user")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, users)
+	assert.Equal(t, "general", msg.Args["channel"])
+
+	msg = &ChatMessage{
+		Match:   "notify",
+		RawArgs: "general alice bob",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+
+	users, _ = msg.ListArg("user")
+	assert.Equal(t, []string{"alice", "bob"}, users)
+
+	msg = &ChatMessage{
+		Match:   "notify",
+		RawArgs: "general",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+
+	_, ok = msg.ListArg("user")
+	assert.False(t, ok)
+}
+
+func TestMixedCaseArgNames(t *testing.T) {
+	ca := &chatAction{}
+	WithOptionalArg("Timeout", "30s", "give up after")(ca)
+	WithFlagArg("DryRun", "only print")(ca)
+
+	msg := &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "TIMEOUT=1m --dryrun",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+
+	timeout, ok := msg.StringArg("Timeout")
+	assert.True(t, ok)
+	assert.Equal(t, "1m", timeout)
+
+	dryRun, _ := msg.Args.Bool("DryRun")
+	assert.True(t, dryRun)
+}
+
+func TestInterleavedArgs(t *testing.T) {
+	ca := &chatAction{}
+	WithRequiredArg("env", "target environment")(ca)
+	WithRequiredArg("app", "what to deploy")(ca)
+	WithOptionalArg("region", "us", "where")(ca)
+
+	for _, rawArgs := range []string{
+		"prod jarbas region=eu",
+		"region=eu prod jarbas",
+		"prod region=eu jarbas",
+	} {
+		msg := &ChatMessage{
+			Match:   "deploy",
+			RawArgs: rawArgs,
+			Args:    ChatArgs{},
+		}
+		assert.Nil(t, parseArguments(ca.args, msg), rawArgs)
+		assert.Equal(t, ChatArgs{"env": "prod", "app": "jarbas", "region": "eu"}, msg.Args, rawArgs)
+	}
+
+	// named args take their slot, positionals fill the rest
+	msg := &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "jarbas env=prod",
+		Args:    ChatArgs{},
+	}
+	assert.Nil(t, parseArguments(ca.args, msg))
+	assert.Equal(t, ChatArgs{"env": "prod", "app": "jarbas", "region": "us"}, msg.Args)
+}
@@ -0,0 +1,29 @@
+package chat
+
+import "github.com/nlopes/slack"
+
+// SendAttachments posts through the web api, attachments can carry colors, fields and buttons.
+// Button clicks are delivered to the slack app's interactivity url.
+func (cb *ChatBot) SendAttachments(target ChatTarget, threadTimestamp string, text string, attachments ...slack.Attachment) (*ChatReply, error) {
+	opts := []slack.MsgOption{
+		slack.MsgOptionAsUser(true),
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionAttachments(attachments...),
+	}
+
+	if threadTimestamp != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTimestamp))
+	}
+
+	_, timestamp, err := cb.slackAPI.PostMessage(target.ID(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChatReply{
+		Bot:       cb,
+		Text:      text,
+		Target:    target,
+		Timestamp: timestamp,
+	}, nil
+}
@@ -2,10 +2,13 @@ package chat
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,16 +16,33 @@ import (
 	"github.com/lxfontes/jarbas/logger"
 	"github.com/lxfontes/jarbas/store"
 	"github.com/nlopes/slack"
+	"github.com/pborman/uuid"
 )
 
 const (
 	EventConnection = "connection"
 	EventReaction   = "reaction"
 	EventPresence   = "presence"
+
+	EventMessageEdited  = "message_edited"
+	EventMessageDeleted = "message_deleted"
+)
+
+const (
+	flagPrefix = "--"
 )
 
 var (
-	ackTimeout = 10 * time.Second
+	ackTimeout  = 10 * time.Second
+	stopTimeout = 30 * time.Second
+
+	// slack shows the indicator for a few seconds after each typing event
+	typingInterval = 3 * time.Second
+
+	// web api lookups for unknown ids give up after directoryTimeout,
+	// failures are remembered for directoryMissTTL so we don't ask again on every message
+	directoryTimeout = 5 * time.Second
+	directoryMissTTL = time.Minute
 )
 
 type ChatHandler interface {
@@ -39,6 +59,7 @@ type ChatReply struct {
 
 	bindCallback func(ev *slack.AckMessage)
 	bindErr      error
+	sendFailed   func(err error) // slack could not write the message to the socket
 }
 
 type ChatMessageHandler interface {
@@ -58,7 +79,10 @@ type ChatEvent struct {
 }
 
 type ChatEventConnection struct {
-	Connected bool
+	Connected   bool
+	Intentional bool  // we asked to disconnect
+	Attempt     int   // failed reconnection attempts so far
+	Err         error // why the last attempt failed
 }
 
 type ChatEventPresence struct {
@@ -74,6 +98,20 @@ type ChatEventReaction struct {
 	Removed   bool
 }
 
+// ChatEventMessageEdited carries the new text, slack's previous_message
+// isn't decoded by our slack client so the old text is not available
+type ChatEventMessageEdited struct {
+	Timestamp string
+	User      *ChatUser
+	Channel   ChatTarget
+	Text      string
+}
+
+type ChatEventMessageDeleted struct {
+	Timestamp string
+	Channel   ChatTarget
+}
+
 type ChatAction struct {
 	handler ChatHandler
 	command bool
@@ -82,8 +120,12 @@ type ChatAction struct {
 type chatArg struct {
 	name        string
 	required    bool
+	flag        bool
 	defValue    string
 	description string
+	choices     []string // valid values, see WithChoiceArg
+	kind        argKind  // values must parse as kind, see WithOptionalIntArg
+	list        bool     // collects repeated values, see WithListArg
 }
 
 type ChatErrorHandler func(handler ChatHandler, err error)
@@ -95,21 +137,46 @@ type ChatExternalUser interface {
 	Token() string
 }
 
-var ErrUserAuthNeeded = errors.New("need auth for site")
+var (
+	ErrUserAuthNeeded = errors.New("need auth for site")
+	ErrNotConnected   = errors.New("not connected to slack")
+	ErrMessageTooLong = fmt.Errorf("message longer than %d characters", slack.MaxMessageTextLength)
+)
 
 type directory struct {
 	// keeps an in-memory representation of our workspace
 	channelIDToName map[string]string
 	userIDToName    map[string]string
-	slackAPI        *slack.Client
+	channelNameToID map[string]string // reverse of channelIDToName, without direct messages
+	userNameToID    map[string]string
+	imIDs           map[string]bool      // direct message channels
+	selfID          string               // the bot's own user id
+	misses          map[string]time.Time // failed lookups by kind and id, until when to skip them
 	mtx             sync.RWMutex
+
+	// web api lookups for ids we haven't seen yet
+	lookupUser    func(id string) (*slack.User, error)
+	lookupChannel func(id string) (*slack.Channel, error)
 }
 
 func newDirectory(slackAPI *slack.Client) *directory {
 	return &directory{
-		slackAPI:        slackAPI,
 		channelIDToName: map[string]string{},
 		userIDToName:    map[string]string{},
+		channelNameToID: map[string]string{},
+		userNameToID:    map[string]string{},
+		imIDs:           map[string]bool{},
+		misses:          map[string]time.Time{},
+		lookupUser: func(id string) (*slack.User, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+			defer cancel()
+			return slackAPI.GetUserInfoContext(ctx, id)
+		},
+		lookupChannel: func(id string) (*slack.Channel, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), directoryTimeout)
+			defer cancel()
+			return slackAPI.GetConversationInfoContext(ctx, id, false)
+		},
 	}
 }
 
@@ -119,17 +186,50 @@ func (d *directory) setup(ev *slack.ConnectedEvent) {
 
 	d.channelIDToName = map[string]string{}
 	d.userIDToName = map[string]string{}
+	d.channelNameToID = map[string]string{}
+	d.userNameToID = map[string]string{}
+	d.imIDs = map[string]bool{}
+	d.misses = map[string]time.Time{}
+
+	if ev.Info.User != nil {
+		d.selfID = ev.Info.User.ID
+	}
 
 	for _, user := range ev.Info.Users {
 		d.userIDToName[user.ID] = user.Name
+		d.userNameToID[user.Name] = user.ID
 	}
 
 	for _, channel := range ev.Info.Channels {
 		d.channelIDToName[channel.ID] = channel.Name
+		d.channelNameToID[channel.Name] = channel.ID
+	}
+
+	for _, im := range ev.Info.IMs {
+		d.imIDs[im.ID] = true
 	}
 }
 
-func (d *directory) userForID(id string) (string, bool) {
+func (d *directory) isSelf(id string) bool {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	return d.selfID != "" && d.selfID == id
+}
+
+// selfMention is how slack encodes a mention of the bot, empty before we connect
+func (d *directory) selfMention() string {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	if d.selfID == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("<@%s>", d.selfID)
+}
+
+func (d *directory) cachedUser(id string) (string, bool) {
 	d.mtx.RLock()
 	defer d.mtx.RUnlock()
 
@@ -137,16 +237,161 @@ func (d *directory) userForID(id string) (string, bool) {
 	return name, ok
 }
 
-func (d *directory) channelForID(id string) (string, bool) {
+func (d *directory) cachedChannel(id string) (string, bool) {
 	d.mtx.RLock()
 	defer d.mtx.RUnlock()
 
 	name, ok := d.channelIDToName[id]
-	if ok {
+	return name, ok
+}
+
+// missed is true while a failed lookup of id is remembered, kind is 'user' or 'channel'
+func (d *directory) missed(kind string, id string) bool {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	until, ok := d.misses[kind+":"+id]
+	return ok && time.Now().Before(until)
+}
+
+func (d *directory) addMiss(kind string, id string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.misses[kind+":"+id] = time.Now().Add(directoryMissTTL)
+}
+
+func (d *directory) cachedIM(id string) bool {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	return d.imIDs[id]
+}
+
+func (d *directory) addIM(id string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.imIDs[id] = true
+}
+
+// addUser also handles renames, the old name stops resolving
+func (d *directory) addUser(id string, name string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if old, ok := d.userIDToName[id]; ok && d.userNameToID[old] == id {
+		delete(d.userNameToID, old)
+	}
+
+	d.userIDToName[id] = name
+	d.userNameToID[name] = id
+}
+
+// addChannel also handles renames, direct messages are not resolvable by name
+func (d *directory) addChannel(id string, name string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if old, ok := d.channelIDToName[id]; ok && d.channelNameToID[old] == id {
+		delete(d.channelNameToID, old)
+	}
+
+	d.channelIDToName[id] = name
+	if !d.imIDs[id] {
+		d.channelNameToID[name] = id
+	}
+}
+
+// channelID resolves '#general' or 'general'
+func (d *directory) channelID(name string) (string, bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	id, ok := d.channelNameToID[strings.TrimPrefix(name, "#")]
+	return id, ok
+}
+
+// userID resolves '@someone' or 'someone'
+func (d *directory) userID(name string) (string, bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+
+	id, ok := d.userNameToID[strings.TrimPrefix(name, "@")]
+	return id, ok
+}
+
+// userForID asks slack about users that joined after we connected
+func (d *directory) userForID(id string) (string, bool) {
+	if name, ok := d.cachedUser(id); ok {
+		return name, ok
+	}
+
+	if d.missed("user", id) {
+		return "", false
+	}
+
+	user, err := d.lookupUser(id)
+	if err != nil {
+		d.addMiss("user", id)
+		return "", false
+	}
+
+	d.addUser(user.ID, user.Name)
+	return user.Name, true
+}
+
+// channelForID asks slack about channels created after we connected
+func (d *directory) channelForID(id string) (string, bool) {
+	if name, ok := d.cachedChannel(id); ok {
 		return name, ok
 	}
 
-	return d.userForID(id)
+	if name, ok := d.cachedUser(id); ok {
+		return name, ok
+	}
+
+	return d.fetchChannel(id)
+}
+
+// isPrivate is true for direct message channels.
+// Falls back to slack's 'D' id prefix when the web api can't tell us.
+func (d *directory) isPrivate(id string) bool {
+	if d.cachedIM(id) {
+		return true
+	}
+
+	if _, ok := d.cachedChannel(id); ok {
+		return false
+	}
+
+	if _, ok := d.fetchChannel(id); ok {
+		return d.cachedIM(id)
+	}
+
+	return strings.HasPrefix(id, "D")
+}
+
+// fetchChannel caches what the web api knows about id, direct messages are named after the other user
+func (d *directory) fetchChannel(id string) (string, bool) {
+	if d.missed("channel", id) {
+		return "", false
+	}
+
+	channel, err := d.lookupChannel(id)
+	if err != nil {
+		d.addMiss("channel", id)
+		return "", false
+	}
+
+	name := channel.Name
+	if channel.IsIM {
+		name, _ = d.userForID(channel.User)
+		d.addIM(channel.ID)
+	}
+
+	d.addChannel(channel.ID, name)
+	return name, true
 }
 
 type ChatAuthHandler interface {
@@ -154,87 +399,368 @@ type ChatAuthHandler interface {
 	Name() string
 }
 
+// ChatDeauthorizer is implemented by auth handlers that can forget a user, see DeauthorizeUser
+type ChatDeauthorizer interface {
+	Deauthorize(user *ChatUser) error
+}
+
 type ChatBot struct {
-	chatHandlers   map[string][]*chatAction // indexed by command, ex: 'say'
-	eventHandlers  map[string][]ChatEventHandler
-	authHandlers   map[string]ChatAuthHandler
-	defaultHandler *chatAction
-	errorHander    *ChatErrorHandler
+	// guards every handler field below, handlers can be added while serving.
+	// Slices are copied on write, readers may keep using what they got.
+	handlersMtx         sync.RWMutex
+	chatHandlers        map[string][]*chatAction // indexed by command, ex: 'say'
+	regexHandlers       []*regexAction
+	eventHandlers       map[string][]ChatEventHandler
+	authHandlers        map[string]ChatAuthHandler
+	interactionHandlers map[string]ChatInteractionHandler // indexed by callback id
+	slashHandlers       map[string]*slashAction           // indexed by command, ex: '/deploy'
+	defaultHandler      *chatAction
+	errorHander         *ChatErrorHandler
 
 	slackAPI *slack.Client
 	slackRTM *slack.RTM
 
 	outgoingIDs sync.Map // used to track outgoing message timestamps (ChatReply)
 	directory   *directory
+	limiter     *rateLimiter // throttles Send per channel
+	schedule    *scheduler   // timers for SendAt
+	sendRetry   sendRetry
+	workers     *workerPool     // nil for unbounded, see WithWorkers
+	health      health          // guarded by mtx
+	presenceIDs map[string]bool // guarded by mtx, see SubscribePresence
+	observer    Observer
 
 	store  store.Store
 	logger logger.Log
+
+	handlers sync.WaitGroup // in-flight handler goroutines
+	draining bool           // guarded by mtx, set once Stop waits on handlers
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+	stopErr  error
+	mtx      sync.Mutex
+}
+
+type botOpt func(*ChatBot)
+
+// WithStore replaces the default in-memory store
+func WithStore(s store.Store) botOpt {
+	return func(cb *ChatBot) {
+		cb.store = s
+	}
 }
 
-func NewChatBot(token string) (*ChatBot, error) {
+// WithRateLimit allows perSecond messages to each channel, after an initial burst.
+// Messages over the limit wait their turn, a rate of 0 disables limiting.
+func WithRateLimit(perSecond float64, burst int) botOpt {
+	return func(cb *ChatBot) {
+		cb.limiter = newRateLimiter(perSecond, burst)
+	}
+}
+
+// WithSendRetry sends again when slack could not write the message to the socket, up to attempts
+// in total, waiting min (doubling up to max) in between. Messages that are not acked in time
+// are never sent again, they may still be queued and go out once reconnected.
+func WithSendRetry(attempts int, min time.Duration, max time.Duration) botOpt {
+	return func(cb *ChatBot) {
+		cb.sendRetry = sendRetry{
+			attempts: attempts,
+			backoff:  retryBackoff{min: min, max: max},
+		}
+	}
+}
+
+func NewChatBot(token string, opts ...botOpt) (*ChatBot, error) {
 	apiClient := slack.New(token)
-	return &ChatBot{
-		chatHandlers:  map[string][]*chatAction{},
-		eventHandlers: map[string][]ChatEventHandler{},
-		authHandlers:  map[string]ChatAuthHandler{},
-		slackAPI:      apiClient,
-		store:         store.NewMemoryStore(),
-		logger:        logger.DefaultLogger(),
-		directory:     newDirectory(apiClient),
-	}, nil
+	cb := &ChatBot{
+		chatHandlers:        map[string][]*chatAction{},
+		eventHandlers:       map[string][]ChatEventHandler{},
+		authHandlers:        map[string]ChatAuthHandler{},
+		interactionHandlers: map[string]ChatInteractionHandler{},
+		slashHandlers:       map[string]*slashAction{},
+		slackAPI:            apiClient,
+		store:               store.NewMemoryStore(),
+		logger:              logger.DefaultLogger(),
+		directory:           newDirectory(apiClient),
+		limiter:             newRateLimiter(defaultSendRate, defaultSendBurst),
+		schedule:            newScheduler(),
+		observer:            nopObserver{},
+	}
+
+	for _, opt := range opts {
+		opt(cb)
+	}
+
+	cb.AddMessageHandler(helpPattern, &helpHandler{},
+		WithDescription("lists commands, or describes the arguments of one"),
+	)
+
+	return cb, nil
 }
 
 func (cb *ChatBot) Store() store.Store {
 	return cb.store
 }
 
+// SlackAPI is an escape hatch for slack features we don't wrap, use at your own risk
+func (cb *ChatBot) SlackAPI() *slack.Client {
+	return cb.slackAPI
+}
+
+// SlackRTM is nil until Serve is called
+func (cb *ChatBot) SlackRTM() *slack.RTM {
+	return cb.slackRTM
+}
+
 func (cb *ChatBot) Serve() {
+	cb.ServeContext(context.Background())
+}
+
+// ServeContext dispatches events until ctx is cancelled or Stop is called
+func (cb *ChatBot) ServeContext(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+
+	cb.mtx.Lock()
+	cb.cancel = cancel
+	cb.stopped = stopped
+	cb.draining = false
+	cb.mtx.Unlock()
+
+	defer close(stopped)
+
 	cb.slackRTM = cb.slackAPI.NewRTM()
 	go cb.slackRTM.ManageConnection()
 
-	for msg := range cb.slackRTM.IncomingEvents {
-		switch ev := msg.Data.(type) {
-		case *slack.HelloEvent:
-			// Ignore hello
-
-		case *slack.ConnectedEvent:
-			cr := &ChatEventConnection{
-				Connected: true,
+	for {
+		select {
+		case <-ctx.Done():
+			cb.stopErr = cb.drain()
+			return
+		case msg := <-cb.slackRTM.IncomingEvents:
+			if !cb.handleEvent(msg) {
+				cancel()
+				return
 			}
-			cb.directory.setup(ev)
-			go cb.emitEvent(EventConnection, cr)
+		}
+	}
+}
+
+// Stop disconnects from slack and waits for running handlers to finish
+func (cb *ChatBot) Stop() error {
+	cb.mtx.Lock()
+	cancel, stopped := cb.cancel, cb.stopped
+	cb.mtx.Unlock()
+
+	if cancel == nil {
+		return errors.New("bot is not serving")
+	}
 
-		case *slack.DisconnectedEvent:
-			cr := &ChatEventConnection{
-				Connected: false,
+	cancel()
+	<-stopped
+
+	return cb.stopErr
+}
+
+// drain waits for in-flight handlers, still processing acks for messages they send
+func (cb *ChatBot) drain() error {
+	defer cb.slackRTM.Disconnect()
+
+	// http requests are turned away from now on, see spawnUnlessDraining
+	cb.mtx.Lock()
+	cb.draining = true
+	cb.mtx.Unlock()
+
+	cb.stopScheduled()
+
+	done := make(chan struct{})
+	go func() {
+		cb.handlers.Wait()
+		close(done)
+	}()
+
+	timeout := time.After(stopTimeout)
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-timeout:
+			cb.Logger().Error("handlers did not finish before stop timeout")
+			return errors.New("timed out waiting for handlers")
+		case msg := <-cb.slackRTM.IncomingEvents:
+			switch ev := msg.Data.(type) {
+			case *slack.AckMessage:
+				cb.handleAck(ev)
+			case *slack.OutgoingErrorEvent:
+				cb.handleSendError(ev.Message.ID, ev.ErrorObj)
+			case *slack.MessageTooLongEvent:
+				cb.handleSendError(ev.Message.ID, ErrMessageTooLong)
 			}
-			go cb.emitEvent(EventConnection, cr)
+		}
+	}
+}
 
-		case *slack.MessageEvent:
-			if ev.SubType == "message_replied" {
-				continue
+// sendRetry is set through WithSendRetry, attempts of 0 or 1 mean no retries
+type sendRetry struct {
+	attempts int
+	backoff  retryBackoff
+}
+
+type retryBackoff struct {
+	min time.Duration
+	max time.Duration
+}
+
+// delay for the nth failed attempt, zero when not configured
+func (rb retryBackoff) delay(attempt int) time.Duration {
+	d := rb.min
+	for i := 1; i < attempt && d < rb.max; i++ {
+		d *= 2
+	}
+
+	if d > rb.max {
+		return rb.max
+	}
+
+	return d
+}
+
+// spawn tracks handler goroutines so Stop can wait on them
+func (cb *ChatBot) spawn(fn func()) {
+	cb.handlers.Add(1)
+	go func() {
+		defer cb.handlers.Done()
+		fn()
+	}()
+}
+
+// spawnUnlessDraining is spawn for work arriving outside the event loop, like http requests.
+// Returns false once drain started, the WaitGroup can't take new work while being waited on.
+func (cb *ChatBot) spawnUnlessDraining(fn func()) bool {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+
+	if cb.draining {
+		return false
+	}
+
+	cb.spawn(fn)
+	return true
+}
+
+// handleEvent returns false when we should stop serving
+func (cb *ChatBot) handleEvent(msg slack.RTMEvent) bool {
+	switch ev := msg.Data.(type) {
+	case *slack.HelloEvent:
+		// Ignore hello
+
+	case *slack.ConnectedEvent:
+		cr := &ChatEventConnection{
+			Connected: true,
+		}
+		cb.directory.setup(ev)
+		cb.setConnected(true)
+		cb.sendPresenceSub()
+		cb.spawn(func() { cb.emitEvent(EventConnection, cr) })
+		cb.spawn(cb.restoreScheduled)
+
+	case *slack.DisconnectedEvent:
+		cr := &ChatEventConnection{
+			Connected:   false,
+			Intentional: ev.Intentional,
+		}
+		cb.setConnected(false)
+		cb.spawn(func() { cb.emitEvent(EventConnection, cr) })
+
+	case *slack.ConnectionErrorEvent:
+		cb.Logger().WithField("attempt", ev.Attempt+1).WithError(ev.ErrorObj).Warning("could not connect")
+		cr := &ChatEventConnection{
+			Connected: false,
+			Attempt:   ev.Attempt + 1,
+			Err:       ev.ErrorObj,
+		}
+		cb.setConnected(false)
+		cb.spawn(func() { cb.emitEvent(EventConnection, cr) })
+
+	case *slack.MessageEvent:
+		switch ev.SubType {
+		case "message_replied":
+			return true
+		case "message_changed":
+			if ev.SubMessage == nil {
+				return true
 			}
-			go cb.handleMessage(ev)
 
-		case *slack.PresenceChangeEvent:
+			// directory lookups can hit the web api, keep them off the event loop
+			cb.spawn(func() {
+				userName, _ := cb.directory.userForID(ev.SubMessage.User)
+				channelName, _ := cb.directory.channelForID(ev.Channel)
+				cr := &ChatEventMessageEdited{
+					Timestamp: ev.SubMessage.Timestamp,
+					Text:      ev.SubMessage.Text,
+					User:      cb.userFor(ev.SubMessage.User, userName),
+					Channel: &ChatChannel{
+						id:   ev.Channel,
+						name: channelName,
+					},
+				}
+				cb.emitEvent(EventMessageEdited, cr)
+			})
+			return true
+		case "message_deleted":
+			cb.spawn(func() {
+				channelName, _ := cb.directory.channelForID(ev.Channel)
+				cr := &ChatEventMessageDeleted{
+					Timestamp: ev.DeletedTimestamp,
+					Channel: &ChatChannel{
+						id:   ev.Channel,
+						name: channelName,
+					},
+				}
+				cb.emitEvent(EventMessageDeleted, cr)
+			})
+			return true
+		}
+		cb.spawnHandler(func() { cb.handleMessage(ev) })
+
+	case *slack.PresenceChangeEvent:
+		cb.spawn(func() {
 			name, _ := cb.directory.userForID(ev.User)
 			cr := &ChatEventPresence{
 				Status: ev.Presence,
 				User:   cb.userFor(ev.User, name),
 			}
-			go cb.emitEvent(EventPresence, cr)
+			cb.emitEvent(EventPresence, cr)
+		})
 
-		case *slack.LatencyReport:
-			cb.Logger().WithField("latency", ev.Value).Info("latency report")
+	case *slack.TeamJoinEvent:
+		cb.directory.addUser(ev.User.ID, ev.User.Name)
 
-		case *slack.RTMError:
-			cb.Logger().WithError(ev).Error("rtm error")
+	case *slack.ChannelCreatedEvent:
+		cb.directory.addChannel(ev.Channel.ID, ev.Channel.Name)
 
-		case *slack.InvalidAuthEvent:
-			cb.Logger().Error("invalid credentials")
-			return
+	case *slack.ChannelRenameEvent:
+		cb.directory.addChannel(ev.Channel.ID, ev.Channel.Name)
+
+	case *slack.UserChangeEvent:
+		cb.directory.addUser(ev.User.ID, ev.User.Name)
+
+	case *slack.IMCreatedEvent:
+		cb.directory.addIM(ev.Channel.ID)
 
-		case *slack.ReactionAddedEvent:
+	case *slack.LatencyReport:
+		cb.Logger().WithField("latency", ev.Value).Info("latency report")
+		cb.setLatency(ev.Value)
+
+	case *slack.RTMError:
+		cb.Logger().WithError(ev).Error("rtm error")
+
+	case *slack.InvalidAuthEvent:
+		cb.Logger().Error("invalid credentials")
+		return false
+
+	case *slack.ReactionAddedEvent:
+		cb.spawn(func() {
 			userName, _ := cb.directory.userForID(ev.User)
 			channelName, _ := cb.directory.channelForID(ev.Item.Channel)
 			cr := &ChatEventReaction{
@@ -246,9 +772,11 @@ func (cb *ChatBot) Serve() {
 					name: channelName,
 				},
 			}
-			go cb.emitEvent(EventReaction, cr)
+			cb.emitEvent(EventReaction, cr)
+		})
 
-		case *slack.ReactionRemovedEvent:
+	case *slack.ReactionRemovedEvent:
+		cb.spawn(func() {
 			userName, _ := cb.directory.userForID(ev.User)
 			channelName, _ := cb.directory.channelForID(ev.Item.Channel)
 			cr := &ChatEventReaction{
@@ -261,25 +789,49 @@ func (cb *ChatBot) Serve() {
 					name: channelName,
 				},
 			}
-			go cb.emitEvent(EventReaction, cr)
+			cb.emitEvent(EventReaction, cr)
+		})
 
-		case *slack.AckMessage:
-			// map our internal id to a slack timestamp
-			item, ok := cb.outgoingIDs.Load(ev.ReplyTo)
-			if !ok {
-				cb.Logger().WithField("message_id", ev.ReplyTo).Warning("received ack for unknown")
-				continue
-			}
-			cb.outgoingIDs.Delete(ev.ReplyTo)
-			cr := item.(*ChatReply)
-			cr.bindCallback(ev)
+	case *slack.AckMessage:
+		cb.handleAck(ev)
 
-		default:
+	case *slack.OutgoingErrorEvent:
+		cb.handleSendError(ev.Message.ID, ev.ErrorObj)
 
-			// Ignore other events..
-			//			fmt.Printf("Unexpected: %s %v\n", msg.Type, msg.Data)
-		}
+	case *slack.MessageTooLongEvent:
+		cb.handleSendError(ev.Message.ID, ErrMessageTooLong)
+
+	default:
+
+		// Ignore other events..
+		//			fmt.Printf("Unexpected: %s %v\n", msg.Type, msg.Data)
 	}
+
+	return true
+}
+
+func (cb *ChatBot) handleAck(ev *slack.AckMessage) {
+	// map our internal id to a slack timestamp
+	item, ok := cb.outgoingIDs.Load(ev.ReplyTo)
+	if !ok {
+		cb.Logger().WithField("message_id", ev.ReplyTo).Warning("received ack for unknown")
+		return
+	}
+	cb.outgoingIDs.Delete(ev.ReplyTo)
+	cr := item.(*ChatReply)
+	cr.bindCallback(ev)
+}
+
+// handleSendError reports messages slack gave up on before they reached the socket
+func (cb *ChatBot) handleSendError(id int, err error) {
+	item, ok := cb.outgoingIDs.Load(id)
+	if !ok {
+		cb.Logger().WithField("message_id", id).WithError(err).Warning("send error for unknown")
+		return
+	}
+	cb.outgoingIDs.Delete(id)
+	cr := item.(*ChatReply)
+	cr.sendFailed(err)
 }
 
 func (cb *ChatBot) emitEvent(eventType string, data interface{}) {
@@ -289,19 +841,36 @@ func (cb *ChatBot) emitEvent(eventType string, data interface{}) {
 		Data: data,
 	}
 
-	for _, handler := range cb.eventHandlers[eventType] {
-		if err := handler.OnChatEvent(ev); err != nil {
-			// TODO: not much we can recover
-			return
+	cb.handlersMtx.RLock()
+	handlers := cb.eventHandlers[eventType]
+	cb.handlersMtx.RUnlock()
+
+	for _, handler := range handlers {
+		// one failing handler must not starve the others
+		if err := cb.callEventHandler(handler, ev); err != nil {
+			withStack(cb.Logger(), err).
+				WithField("event", eventType).
+				WithField("handler", handler.Name()).
+				WithError(err).
+				Error("event handler failed")
 		}
 	}
 }
 
+func (cb *ChatBot) callEventHandler(handler ChatEventHandler, ev *ChatEvent) (err error) {
+	defer recoverHandler(&err)
+	return handler.OnChatEvent(ev)
+}
+
 func (cb *ChatBot) Logger() logger.Log {
 	return cb.logger
 }
 
 func (cb *ChatBot) SendPrivately(user *ChatUser, threadTimestamp string, s string, args ...interface{}) (*ChatReply, error) {
+	return cb.sendPrivately(context.Background(), user, threadTimestamp, fmt.Sprintf(s, args...))
+}
+
+func (cb *ChatBot) sendPrivately(ctx context.Context, user *ChatUser, threadTimestamp string, text string) (*ChatReply, error) {
 	// FUUUUUUUUUUUUUUU
 	// need to reach out via regular api in order to open a channel with user
 	// it *might* be already open, but we don't care
@@ -315,41 +884,215 @@ func (cb *ChatBot) SendPrivately(user *ChatUser, threadTimestamp string, s strin
 		id:   channelID,
 	}
 
-	return cb.Send(target, threadTimestamp, s, args...)
+	return cb.send(ctx, target, threadTimestamp, false, text)
 }
 
-func (cb *ChatBot) Send(target ChatTarget, threadTimestamp string, s string, args ...interface{}) (*ChatReply, error) {
+// SendTyping shows the bot as typing in target
+func (cb *ChatBot) SendTyping(target ChatTarget) error {
+	// the RTM queues outgoing messages while reconnecting, a full queue would block us
+	if cb.slackRTM == nil || !cb.Connected() {
+		return ErrNotConnected
+	}
+
+	cb.slackRTM.SendMessage(cb.slackRTM.NewTypingMessage(target.ID()))
+	return nil
+}
+
+// KeepTyping sends typing events to target until done is closed
+func (cb *ChatBot) KeepTyping(target ChatTarget, done <-chan struct{}) {
+	ticker := time.NewTicker(typingInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := cb.SendTyping(target); err != nil {
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendEphemeral posts a message in target that only user can see
+func (cb *ChatBot) SendEphemeral(target ChatTarget, user *ChatUser, threadTimestamp string, s string, args ...interface{}) (*ChatReply, error) {
 	text := fmt.Sprintf(s, args...)
 
+	opts := []slack.MsgOption{
+		slack.MsgOptionAsUser(true),
+		slack.MsgOptionText(text, false),
+	}
+
+	if threadTimestamp != "" {
+		opts = append(opts, slack.MsgOptionTS(threadTimestamp))
+	}
+
+	timestamp, err := cb.slackAPI.PostEphemeral(target.ID(), user.ID(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChatReply{
+		Bot:       cb,
+		Text:      text,
+		Target:    target,
+		Timestamp: timestamp,
+	}, nil
+}
+
+func (cb *ChatBot) Send(target ChatTarget, threadTimestamp string, s string, args ...interface{}) (*ChatReply, error) {
+	return cb.send(context.Background(), target, threadTimestamp, false, fmt.Sprintf(s, args...))
+}
+
+// SendContext gives up waiting for the outgoing queue or slack's ack once ctx is done,
+// returning ctx.Err(). The message may still show up in slack.
+func (cb *ChatBot) SendContext(ctx context.Context, target ChatTarget, threadTimestamp string, s string, args ...interface{}) (*ChatReply, error) {
+	return cb.send(ctx, target, threadTimestamp, false, fmt.Sprintf(s, args...))
+}
+
+// SendBroadcast replies in the thread and also shows the reply in target, slack's "also send to channel"
+func (cb *ChatBot) SendBroadcast(target ChatTarget, threadTimestamp string, s string, args ...interface{}) (*ChatReply, error) {
+	return cb.send(context.Background(), target, threadTimestamp, true, fmt.Sprintf(s, args...))
+}
+
+func (cb *ChatBot) send(ctx context.Context, target ChatTarget, threadTimestamp string, broadcast bool, text string) (*ChatReply, error) {
 	cr := &ChatReply{
 		Bot:    cb,
 		Text:   text,
 		Target: target,
 	}
 
-	msg := cb.slackRTM.NewOutgoingMessage(text, target.ID())
-	msg.ThreadTimestamp = threadTimestamp
+	if cb.slackRTM == nil {
+		cb.observer.MessageSent(SendError)
+		return nil, ErrNotConnected
+	}
+
+	if err := cb.limiter.wait(ctx, target.ID()); err != nil {
+		return nil, err
+	}
 
-	ch := make(chan struct{})
+	// buffered & non-blocking, a late ack after we gave up must not block or panic
+	ch := make(chan *slack.AckMessage, 1)
 	cr.bindCallback = func(ev *slack.AckMessage) {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+
+	acked := func(ev *slack.AckMessage) (*ChatReply, error) {
 		cr.Timestamp = ev.Timestamp
-		close(ch)
+		if cr.bindErr != nil {
+			cb.observer.MessageSent(SendError)
+		} else {
+			cb.observer.MessageSent(SendOK)
+		}
+		return cr, cr.bindErr
+	}
+
+	// only attempts that never reached the socket are retried, see WithSendRetry
+	failed := make(chan error, 1)
+	cr.sendFailed = func(err error) {
+		select {
+		case failed <- err:
+		default:
+		}
 	}
 
-	cb.outgoingIDs.Store(msg.ID, cr)
+	// every attempt stays tracked until we return, a late ack for any of them
+	// means the message went out and stops further retries
+	sentIDs := []int{}
+	defer func() {
+		for _, id := range sentIDs {
+			cb.outgoingIDs.Delete(id)
+		}
+	}()
 
 	ll := cb.Logger().WithField("target_id", target.ID()).WithField("thread", threadTimestamp).WithField("text", text)
-	ll.Debug("outgoing message")
-	cb.slackRTM.SendMessage(msg)
+	if traceID := TraceID(ctx); traceID != "" {
+		ll = ll.WithField("trace_id", traceID)
+	}
+
+	for attempt := 1; ; attempt++ {
+		msg := cb.slackRTM.NewOutgoingMessage(text, target.ID())
+		msg.ThreadTimestamp = threadTimestamp
+		msg.ThreadBroadcast = broadcast && threadTimestamp != ""
+
+		cb.outgoingIDs.Store(msg.ID, cr)
+		sentIDs = append(sentIDs, msg.ID)
+
+		ll.WithField("attempt", attempt).Debug("outgoing message")
+		if err := cb.queue(ctx, msg); err != nil {
+			ll.WithError(err).Info("stopped waiting to queue message")
+			return nil, err
+		}
+
+		var sendErr error
+		select {
+		case ev := <-ch:
+			return acked(ev)
+		case sendErr = <-failed:
+			cb.observer.MessageSent(SendError)
+			ll.WithField("attempt", attempt).WithError(sendErr).Error("could not send message")
+		case <-time.After(ackTimeout):
+			// possibly still queued or sent, sending again could post it twice
+			cb.observer.MessageSent(SendTimeout)
+			ll.WithField("attempt", attempt).Error("did not ack message")
+			return nil, errors.New("could not confirm msg was sent")
+		case <-ctx.Done():
+			ll.WithError(ctx.Err()).Info("stopped waiting for ack")
+			return nil, ctx.Err()
+		}
+
+		if sendErr == ErrMessageTooLong || attempt >= cb.sendRetry.attempts {
+			return nil, sendErr
+		}
+
+		select {
+		case ev := <-ch:
+			return acked(ev)
+		case <-time.After(cb.sendRetry.backoff.delay(attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// queue hands msg to the rtm, which blocks while its outgoing buffer is full (ex: disconnected).
+// Once ctx is done we stop waiting, the message is still sent if the buffer frees up.
+func (cb *ChatBot) queue(ctx context.Context, msg *slack.OutgoingMessage) error {
+	queued := make(chan struct{})
+	go func() {
+		cb.slackRTM.SendMessage(msg)
+		close(queued)
+	}()
 
 	select {
-	case <-ch:
-		return cr, cr.bindErr
-	case <-time.After(ackTimeout):
-		ll.Error("did not ack message")
+	case <-queued:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// UpdateMessage edits a message in place, returning the new text
+func (cb *ChatBot) UpdateMessage(target ChatTarget, timestamp string, s string, args ...interface{}) (string, error) {
+	text := fmt.Sprintf(s, args...)
+	_, _, _, err := cb.slackAPI.UpdateMessage(target.ID(), timestamp, slack.MsgOptionText(text, false))
+
+	return text, err
+}
+
+// DeleteMessage removes a message, deleting an already deleted message is not an error
+func (cb *ChatBot) DeleteMessage(target ChatTarget, timestamp string) error {
+	_, _, err := cb.slackAPI.DeleteMessage(target.ID(), timestamp)
+	if err != nil && err.Error() == "message_not_found" {
+		return nil
 	}
 
-	return nil, errors.New("could not confirm msg was sent")
+	return err
 }
 
 func (cb *ChatBot) AddReaction(msg *ChatMessage, reaction string) error {
@@ -364,25 +1107,59 @@ func (cb *ChatBot) RemoveReaction(msg *ChatMessage, reaction string) error {
 
 func parseArguments(specArgs []chatArg, msg *ChatMessage) error {
 	scanner := bufio.NewScanner(strings.NewReader(msg.RawArgs))
-	scanner.Split(ScanQuotedWords)
+
+	// only bare tokens are flags, quoting or escaping "--force" makes it a value
+	bare := false
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := ScanQuotedWords(data, atEOF)
+		if token != nil {
+			raw := strings.TrimLeftFunc(string(data[:advance]), isSpace)
+			bare = strings.HasPrefix(raw, flagPrefix)
+		}
+		return advance, token, err
+	})
 
 	argStack := make([]chatArg, len(specArgs))
 	copy(argStack, specArgs)
-	// we cannot rely on positional arg after a named arg
-	canNamed := true
+
+	// flags & named args are pulled out first, whatever is left fills the remaining args in order
+	positional := []string{}
 	for scanner.Scan() {
 		token := scanner.Text()
 
-		if HasMarker(token) {
-			if !canNamed {
-				msg.Logger.WithField("args", msg.RawArgs).Error("switching from positional to name not allowed")
-				return errors.New("mixed argument mode")
+		if bare && strings.HasPrefix(token, flagPrefix) {
+			flagName := strings.TrimPrefix(token, flagPrefix)
+			found := false
+			for i := 0; i < len(argStack); i++ {
+				arg := argStack[i]
+				if arg.flag && strings.EqualFold(flagName, arg.name) {
+					msg.Args[arg.name] = "true"
+					argStack = append(argStack[:i], argStack[i+1:]...)
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("unknown flag %q", token)
 			}
+
+			continue
+		}
+
+		if HasMarker(token) {
 			argName, argValue := SplitMarker(token)
 
 			for i := 0; i < len(argStack); i++ {
 				arg := argStack[i]
-				if strings.ToLower(argName) == arg.name {
+				// names are matched case-insensitively, values keep the spec's casing
+				if strings.EqualFold(argName, arg.name) {
+					// lists stay around for the next occurrence
+					if arg.list {
+						msg.addListArg(arg.name, argValue)
+						break
+					}
+
 					msg.Args[arg.name] = argValue
 					argStack = append(argStack[:i], argStack[i+1:]...)
 					break
@@ -392,30 +1169,108 @@ func parseArguments(specArgs []chatArg, msg *ChatMessage) error {
 			continue
 		}
 
-		// no gymnastics, just pop an argument
-		var arg chatArg
-		canNamed = false
-		arg, argStack = argStack[0], argStack[1:]
-		msg.Args[arg.name] = token
+		positional = append(positional, token)
 	}
 
 	if err := scanner.Err(); err != nil {
 		return err
 	}
 
+	for _, token := range positional {
+		// no gymnastics, just pop an argument (flags are never positional)
+		i := 0
+		for i < len(argStack) && argStack[i].flag {
+			i++
+		}
+
+		if i == len(argStack) {
+			return fmt.Errorf("unexpected argument %q", token)
+		}
+
+		if argStack[i].list {
+			// swallows the remaining positional arguments
+			msg.addListArg(argStack[i].name, token)
+			continue
+		}
+
+		msg.Args[argStack[i].name] = token
+		argStack = append(argStack[:i], argStack[i+1:]...)
+	}
+
 	// apply optionals & fail defaults
 	for _, arg := range argStack {
-		if arg.required == true {
-			return errors.New("missed required arg" + arg.name)
-			continue // remove this
+		if arg.list {
+			continue
+		}
+
+		if arg.required {
+			return fmt.Errorf("missing required argument %q for command %q", arg.name, msg.Match)
 		}
 
 		msg.Args[arg.name] = arg.defValue
 	}
 
+	for _, arg := range specArgs {
+		if arg.list {
+			continue
+		}
+
+		if err := arg.validate(msg.Args[arg.name]); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// validate enforces WithChoiceArg and typed args
+func (arg *chatArg) validate(value string) error {
+	if err := arg.kind.validate(value); err != nil {
+		return fmt.Errorf("invalid value %q for argument %q: %s", value, arg.name, err)
+	}
+
+	if len(arg.choices) == 0 {
+		return nil
+	}
+
+	for _, choice := range arg.choices {
+		if value == choice {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid value %q for argument %q, must be one of: %s", value, arg.name, strings.Join(arg.choices, ", "))
+}
+
+// User looks up a slack user by id
+func (cb *ChatBot) User(id string) *ChatUser {
+	name, _ := cb.directory.userForID(id)
+	return cb.userFor(id, name)
+}
+
+// UserByName finds a user we know about, ex: '@someone'
+func (cb *ChatBot) UserByName(name string) (*ChatUser, bool) {
+	id, ok := cb.directory.userID(name)
+	if !ok {
+		return nil, false
+	}
+
+	return cb.User(id), true
+}
+
+// ChannelByName finds a channel we know about, ex: '#general'
+func (cb *ChatBot) ChannelByName(name string) (ChatTarget, bool) {
+	id, ok := cb.directory.channelID(name)
+	if !ok {
+		return nil, false
+	}
+
+	return &ChatChannel{
+		id:   id,
+		name: strings.TrimPrefix(name, "#"),
+	}, true
+}
+
 func (cb *ChatBot) userFor(id string, name string) *ChatUser {
 	return &ChatUser{
 		ll:   cb.Logger(),
@@ -444,8 +1299,11 @@ func (cb *ChatBot) unformat(rawText string) string {
 		}
 
 		if pattern[0] == '@' {
-			id, _ := cb.directory.userForID(pattern[1:])
-			return id
+			// keep the raw id when we don't know the user
+			if name, ok := cb.directory.userForID(pattern[1:]); ok {
+				return name
+			}
+			return pattern[1:]
 		}
 
 		return pattern
@@ -454,8 +1312,61 @@ func (cb *ChatBot) unformat(rawText string) string {
 	return reUnformat.ReplaceAllStringFunc(rawText, unwrapperFn)
 }
 
+// matchRegexHandler returns the first regex handler matching text, in registration order
+func (cb *ChatBot) matchRegexHandler(text string, isPrivate bool, isMention bool) (*regexAction, []string) {
+	cb.handlersMtx.RLock()
+	defer cb.handlersMtx.RUnlock()
+
+	for _, ra := range cb.regexHandlers {
+		if !ra.action.accepts(isPrivate, isMention) {
+			continue
+		}
+
+		if matches := ra.re.FindStringSubmatch(text); matches != nil {
+			return ra, matches
+		}
+	}
+
+	return nil, nil
+}
+
+type handlerMatch struct {
+	pattern string
+	actions []*chatAction
+}
+
+// matchHandlers returns every registered pattern prefixing text, longest first
+func (cb *ChatBot) matchHandlers(text string) []handlerMatch {
+	var matches []handlerMatch
+
+	cb.handlersMtx.RLock()
+	for p, ch := range cb.chatHandlers {
+		if strings.HasPrefix(text, p) {
+			matches = append(matches, handlerMatch{pattern: p, actions: ch})
+		}
+	}
+	cb.handlersMtx.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return len(matches[i].pattern) > len(matches[j].pattern)
+	})
+
+	return matches
+}
+
 func (cb *ChatBot) handleMessage(ev *slack.MessageEvent) {
-	isPrivate := false
+	// our own replies come back as messages, avoid echo loops
+	if cb.directory.isSelf(ev.User) {
+		return
+	}
+
+	cb.observer.MessageReceived()
+
+	if ev.Channel == "" {
+		cb.Logger().WithField("user", ev.User).WithField("text", ev.Text).Warning("message without channel")
+		return
+	}
+
 	rawText := ev.Text
 	plainText := cb.unformat(rawText)
 
@@ -463,31 +1374,26 @@ func (cb *ChatBot) handleMessage(ev *slack.MessageEvent) {
 	userTarget := cb.userFor(ev.User, userName)
 
 	channelName, _ := cb.directory.channelForID(ev.Channel)
-
-	// this is a direct message
-	if ev.Channel[0] == 'D' {
-		isPrivate = true
-	}
+	isPrivate := cb.directory.isPrivate(ev.Channel)
 
 	channelTarget := &ChatChannel{
 		id:   ev.Channel,
 		name: channelName,
 	}
 
-	var handlers []*chatAction
-	var rawArgs string
-	var pattern string
-
-	for p, ch := range cb.chatHandlers {
-		if strings.HasPrefix(plainText, p) {
-			handlers = ch
-			pattern = p
-			rawArgs = strings.TrimSpace(strings.TrimPrefix(plainText, p))
-			break
-		}
+	// commands can be addressed to us, ex: '@jarbas deploy'
+	isMention := false
+	commandText := plainText
+	if mention := cb.directory.selfMention(); mention != "" && strings.Contains(rawText, mention) {
+		isMention = true
+		commandText = cb.unformat(strings.TrimLeft(strings.TrimPrefix(rawText, mention), ": "))
 	}
 
+	// follows replies to this message around the logs
+	traceID := uuid.New()
+
 	ll := cb.Logger().
+		WithField("trace_id", traceID).
 		WithField("from", userTarget.Name()).
 		WithField("channel", channelTarget.Name()).
 		WithField("text", rawText).
@@ -495,57 +1401,145 @@ func (cb *ChatBot) handleMessage(ev *slack.MessageEvent) {
 
 	ll.Info("incoming message")
 
-	if len(handlers) == 0 {
-		if cb.defaultHandler != nil {
-			msg := &ChatMessage{
-				Logger:          ll,
-				Text:            rawText,
-				PlainText:       plainText,
-				Timestamp:       ev.Timestamp,
-				ThreadTimestamp: ev.ThreadTimestamp,
-				Bot:             cb,
-				IsPrivate:       isPrivate,
-				Args:            ChatArgs{},
-				User:            userTarget,
-				Channel:         channelTarget,
+	// copied for every handler we invoke
+	base := ChatMessage{
+		TraceID:         traceID,
+		Logger:          ll,
+		Text:            rawText,
+		PlainText:       plainText,
+		Timestamp:       ev.Timestamp,
+		ThreadTimestamp: ev.ThreadTimestamp,
+		Bot:             cb,
+		IsPrivate:       isPrivate,
+		IsMention:       isMention,
+		IsBot:           ev.BotID != "" || ev.SubType == "bot_message",
+		User:            userTarget,
+		Channel:         channelTarget,
+	}
+
+	// longest pattern first, shorter ones only run after passthrough handlers
+	for _, match := range cb.matchHandlers(commandText) {
+		rawArgs := strings.TrimSpace(strings.TrimPrefix(commandText, match.pattern))
+		if !cb.runHandlers(base, match, rawArgs) {
+			return
+		}
+	}
+
+	if ra, matches := cb.matchRegexHandler(commandText, isPrivate, isMention); ra != nil {
+		msg := base
+		msg.Match = matches[0]
+		msg.Args = ChatArgs{}
+
+		// named groups become arguments
+		for i, name := range ra.re.SubexpNames() {
+			if name != "" {
+				msg.Args[name] = matches[i]
 			}
-			cb.handleError(msg, cb.defaultHandler.handler.OnChatMessage(msg))
 		}
 
+		cb.handleError(ra.action.handler, &msg, cb.invoke(ra.action, &msg))
 		return
 	}
 
-	for _, ca := range handlers {
-		msg := &ChatMessage{
-			Logger:          ll,
-			Text:            rawText,
-			PlainText:       plainText,
-			RawArgs:         rawArgs,
-			Match:           pattern,
-			Timestamp:       ev.Timestamp,
-			ThreadTimestamp: ev.ThreadTimestamp,
-			Bot:             cb,
-			IsPrivate:       isPrivate,
-			Args:            ChatArgs{},
-			User:            userTarget,
-			Channel:         channelTarget,
+	cb.handlersMtx.RLock()
+	defaultHandler := cb.defaultHandler
+	cb.handlersMtx.RUnlock()
+
+	if defaultHandler != nil {
+		msg := base
+		msg.Args = ChatArgs{}
+		cb.handleError(defaultHandler.handler, &msg, cb.invoke(defaultHandler, &msg))
+	}
+}
+
+// runHandlers invokes the actions registered for match.
+// Returns true when only passthrough handlers ran, so lower priority handlers get a go.
+func (cb *ChatBot) runHandlers(base ChatMessage, match handlerMatch, rawArgs string) bool {
+	ran := false
+	passthrough := true
+
+	for _, ca := range match.actions {
+		if !ca.accepts(base.IsPrivate, base.IsMention) {
+			continue
 		}
 
+		ran = true
+		passthrough = passthrough && ca.passthrough
+
+		msg := base
+		msg.Match = match.pattern
+		msg.RawArgs = rawArgs
+		msg.Args = ChatArgs{}
+
 		if len(ca.args) > 0 {
-			parseArguments(ca.args, msg)
+			if err := parseArguments(ca.args, &msg); err != nil {
+				cb.replyUsage(&msg, err)
+				continue
+			}
+		}
+
+		cb.handleError(ca.handler, &msg, cb.invoke(ca, &msg))
+	}
+
+	return ran && passthrough
+}
+
+// invoke runs the handler, authorizing the user first when the action requires it
+func (cb *ChatBot) invoke(ca *chatAction, msg *ChatMessage) (err error) {
+	cb.observer.HandlerInvoked(ca.handler.Name())
+	defer recoverHandler(&err)
+
+	if ca.authSite != "" {
+		externalUser, err := cb.AuthorizeUser(msg.User, ca.authSite, ca.authRole)
+		if err != nil {
+			return err
 		}
 
-		cb.handleError(msg, ca.handler.OnChatMessage(msg))
+		msg.ExternalUser = externalUser
 	}
+
+	return ca.handler.OnChatMessage(msg)
+}
+
+// replyUsage tells the user why their arguments were rejected
+func (cb *ChatBot) replyUsage(msg *ChatMessage, err error) {
+	msg.Logger.WithError(err).Info("could not parse arguments")
+
+	usage, _ := cb.Usage(msg.Match)
+	msg.ReplyInThread("%s\n%s", err, usage)
 }
 
-func (cb *ChatBot) handleError(msg *ChatMessage, err error) {
+// SetErrorHandler is called for every handler error, users still get a DM about it
+func (cb *ChatBot) SetErrorHandler(errorHandler ChatErrorHandler) {
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	cb.errorHander = &errorHandler
+}
+
+func (cb *ChatBot) handleError(handler ChatHandler, msg *ChatMessage, err error) {
 	switch err {
 	case nil:
 		return
 	case ErrUserAuthNeeded:
 		msg.ReplyPrivately("Auth needed")
 	default:
+		cb.observer.HandlerFailed(handler.Name())
+
+		cb.handlersMtx.RLock()
+		errorHandler := cb.errorHander
+		cb.handlersMtx.RUnlock()
+
+		if errorHandler != nil {
+			(*errorHandler)(handler, err)
+		}
+
+		if _, ok := err.(*handlerPanic); ok {
+			withStack(msg.Logger, err).WithField("handler", handler.Name()).WithError(err).Error("handler crashed")
+			msg.ReplyPrivately("Your last command crashed, sorry about that")
+			return
+		}
+
 		msg.ReplyPrivately("Your last command emmited an error")
 		msg.ReplyPrivately("%+v", err)
 	}
@@ -575,6 +1569,9 @@ func (cb *ChatBot) SendSnippet(target ChatTarget, name string, title string, sni
 }
 
 func (cb *ChatBot) AddAuthHandler(authHandler ChatAuthHandler) error {
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
 	if _, ok := cb.authHandlers[authHandler.Name()]; ok {
 		return errors.New("site already present")
 	}
@@ -584,19 +1581,141 @@ func (cb *ChatBot) AddAuthHandler(authHandler ChatAuthHandler) error {
 }
 
 func (cb *ChatBot) AuthorizeUser(user *ChatUser, site string, role string) (ChatExternalUser, error) {
-	handler, ok := cb.authHandlers[site]
+	handler, ok := cb.authHandler(site)
 	if !ok {
 		return nil, errors.New("no handler for site")
 	}
 
-	return handler.Authorize(user, role)
+	externalUser, err := handler.Authorize(user, role)
+	if eu, ok := externalUser.(*chatExternalUser); ok && eu.site == "" {
+		eu.site = site
+	}
+
+	return externalUser, err
+}
+
+// DeauthorizeUser forgets whatever site knows about user
+func (cb *ChatBot) DeauthorizeUser(user *ChatUser, site string) error {
+	handler, ok := cb.authHandler(site)
+	if !ok {
+		return errors.New("no handler for site")
+	}
+
+	deauthorizer, ok := handler.(ChatDeauthorizer)
+	if !ok {
+		return errors.New("site can't deauthorize users")
+	}
+
+	return deauthorizer.Deauthorize(user)
+}
+
+func (cb *ChatBot) authHandler(site string) (ChatAuthHandler, bool) {
+	cb.handlersMtx.RLock()
+	defer cb.handlersMtx.RUnlock()
+
+	handler, ok := cb.authHandlers[site]
+	return handler, ok
 }
 
 func (cb *ChatBot) AddEventHandler(eventType string, handler ChatEventHandler) error {
-	cb.eventHandlers[eventType] = append(cb.eventHandlers[eventType], handler)
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	cb.eventHandlers[eventType] = appendEventHandler(cb.eventHandlers[eventType], handler)
 	return nil
 }
 
+// RemoveEventHandler undoes AddEventHandler, see sameHandler for how handlers are compared
+func (cb *ChatBot) RemoveEventHandler(eventType string, handler ChatEventHandler) error {
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	if !canCompare(handler) {
+		return errUncomparableHandler
+	}
+
+	handlers := cb.eventHandlers[eventType]
+	for i, h := range handlers {
+		if !sameHandler(h, handler) {
+			continue
+		}
+
+		if len(handlers) == 1 {
+			delete(cb.eventHandlers, eventType)
+			return nil
+		}
+
+		// copy on write, see handlersMtx
+		updated := make([]ChatEventHandler, 0, len(handlers)-1)
+		cb.eventHandlers[eventType] = append(append(updated, handlers[:i]...), handlers[i+1:]...)
+		return nil
+	}
+
+	return errors.New("handler not present")
+}
+
+// Patterns lists registered command patterns, sorted
+func (cb *ChatBot) Patterns() []string {
+	cb.handlersMtx.RLock()
+	defer cb.handlersMtx.RUnlock()
+
+	patterns := []string{}
+	for pattern := range cb.chatHandlers {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.Strings(patterns)
+	return patterns
+}
+
+// AddRegexHandler triggers handler when pattern matches, named capture groups are available as Args.
+// Like prefix handlers, pattern sees the text following a leading mention, ex: '^deploy (\w+)'.
+// Regex handlers are only evaluated when no prefix handler claimed the message, see WithPassthrough.
+func (cb *ChatBot) AddRegexHandler(pattern *regexp.Regexp, handler ChatMessageHandler, opts ...chatOpt) error {
+	ca := &chatAction{
+		handler: handler,
+	}
+
+	for _, opt := range opts {
+		opt(ca)
+	}
+
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	// copy on write, see handlersMtx
+	regexHandlers := make([]*regexAction, len(cb.regexHandlers), len(cb.regexHandlers)+1)
+	copy(regexHandlers, cb.regexHandlers)
+	cb.regexHandlers = append(regexHandlers, &regexAction{
+		re:     pattern,
+		action: ca,
+	})
+	return nil
+}
+
+// RemoveRegexHandler undoes AddRegexHandler, patterns match when their source is the same
+func (cb *ChatBot) RemoveRegexHandler(pattern *regexp.Regexp, handler ChatMessageHandler) error {
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	if !canCompare(handler) {
+		return errUncomparableHandler
+	}
+
+	for i, ra := range cb.regexHandlers {
+		if ra.re.String() != pattern.String() || !sameHandler(ra.action.handler, handler) {
+			continue
+		}
+
+		// copy on write, see handlersMtx
+		updated := make([]*regexAction, 0, len(cb.regexHandlers)-1)
+		cb.regexHandlers = append(append(updated, cb.regexHandlers[:i]...), cb.regexHandlers[i+1:]...)
+		return nil
+	}
+
+	return errors.New("handler not present")
+}
+
 func (cb *ChatBot) AddMessageHandler(pattern string, handler ChatMessageHandler, opts ...chatOpt) error {
 
 	ca := &chatAction{
@@ -607,6 +1726,65 @@ func (cb *ChatBot) AddMessageHandler(pattern string, handler ChatMessageHandler,
 		opt(ca)
 	}
 
-	cb.chatHandlers[pattern] = append(cb.chatHandlers[pattern], ca)
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	cb.chatHandlers[pattern] = appendAction(cb.chatHandlers[pattern], ca)
 	return nil
 }
+
+// RemoveMessageHandler undoes AddMessageHandler, see sameHandler for how handlers are compared.
+// Messages already being handled still run it.
+func (cb *ChatBot) RemoveMessageHandler(pattern string, handler ChatMessageHandler) error {
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	if !canCompare(handler) {
+		return errUncomparableHandler
+	}
+
+	actions := cb.chatHandlers[pattern]
+	for i, ca := range actions {
+		if !sameHandler(ca.handler, handler) {
+			continue
+		}
+
+		if len(actions) == 1 {
+			// forgotten by help & Patterns too
+			delete(cb.chatHandlers, pattern)
+			return nil
+		}
+
+		// copy on write, see handlersMtx
+		updated := make([]*chatAction, 0, len(actions)-1)
+		cb.chatHandlers[pattern] = append(append(updated, actions[:i]...), actions[i+1:]...)
+		return nil
+	}
+
+	return errors.New("handler not present")
+}
+
+var errUncomparableHandler = errors.New("handler can't be compared, register a pointer to remove it later")
+
+// canCompare is false for handlers == would panic on, ex: func types
+func canCompare(handler interface{}) bool {
+	return handler != nil && reflect.TypeOf(handler).Comparable()
+}
+
+// sameHandler compares by identity, a handler registered twice is removed one registration at a time
+func sameHandler(registered interface{}, handler interface{}) bool {
+	return canCompare(registered) && registered == handler
+}
+
+// appendAction & appendEventHandler copy on write, see handlersMtx
+func appendAction(actions []*chatAction, ca *chatAction) []*chatAction {
+	updated := make([]*chatAction, len(actions), len(actions)+1)
+	copy(updated, actions)
+	return append(updated, ca)
+}
+
+func appendEventHandler(handlers []ChatEventHandler, handler ChatEventHandler) []ChatEventHandler {
+	updated := make([]ChatEventHandler, len(handlers), len(handlers)+1)
+	copy(updated, handlers)
+	return append(updated, handler)
+}
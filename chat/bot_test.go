@@ -0,0 +1,1106 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lxfontes/jarbas/store"
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingHandler struct {
+	name     string
+	messages []*ChatMessage
+}
+
+var _ ChatMessageHandler = &recordingHandler{}
+
+func (rh *recordingHandler) Name() string {
+	return rh.name
+}
+
+func (rh *recordingHandler) OnChatMessage(msg *ChatMessage) error {
+	rh.messages = append(rh.messages, msg)
+	return nil
+}
+
+var errOffline = errors.New("offline")
+
+type recordingEventHandler struct {
+	events []*ChatEvent
+	mtx    sync.Mutex
+}
+
+func (re *recordingEventHandler) Name() string {
+	return "recorder"
+}
+
+func (re *recordingEventHandler) OnChatEvent(ev *ChatEvent) error {
+	re.mtx.Lock()
+	defer re.mtx.Unlock()
+
+	re.events = append(re.events, ev)
+	return nil
+}
+
+func testBot(t *testing.T) *ChatBot {
+	cb, err := NewChatBot("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keep tests off the network
+	cb.directory.lookupUser = func(id string) (*slack.User, error) {
+		return nil, errOffline
+	}
+	cb.directory.lookupChannel = func(id string) (*slack.Channel, error) {
+		return nil, errOffline
+	}
+
+	return cb
+}
+
+func testMessageEvent(text string) *slack.MessageEvent {
+	return &slack.MessageEvent{
+		Msg: slack.Msg{
+			Channel:   "C123",
+			User:      "U123",
+			Text:      text,
+			Timestamp: "1234.5678",
+		},
+	}
+}
+
+func TestLongestPatternWins(t *testing.T) {
+	cb := testBot(t)
+
+	short := &recordingHandler{name: "short"}
+	long := &recordingHandler{name: "long"}
+	cb.AddMessageHandler("log", short)
+	cb.AddMessageHandler("log show", long)
+
+	// map iteration is random, make sure we don't get lucky
+	for i := 0; i < 20; i++ {
+		cb.handleMessage(testMessageEvent("log show today"))
+	}
+
+	assert.Len(t, short.messages, 0)
+	if assert.Len(t, long.messages, 20) {
+		assert.Equal(t, "log show", long.messages[0].Match)
+		assert.Equal(t, "today", long.messages[0].RawArgs)
+	}
+
+	cb.handleMessage(testMessageEvent("log save something"))
+	if assert.Len(t, short.messages, 1) {
+		assert.Equal(t, "log", short.messages[0].Match)
+		assert.Equal(t, "save something", short.messages[0].RawArgs)
+	}
+}
+
+func TestRegexHandler(t *testing.T) {
+	cb := testBot(t)
+
+	prefix := &recordingHandler{name: "prefix"}
+	regex := &recordingHandler{name: "regex"}
+	cb.AddMessageHandler("deploy", prefix)
+	cb.AddRegexHandler(regexp.MustCompile(`please ship (?P<app>\w+) to (?P<env>prod|staging)`), regex)
+
+	cb.handleMessage(testMessageEvent("can you please ship jarbas to staging"))
+	if assert.Len(t, regex.messages, 1) {
+		msg := regex.messages[0]
+		assert.Equal(t, "please ship jarbas to staging", msg.Match)
+
+		app, _ := msg.StringArg("app")
+		assert.Equal(t, "jarbas", app)
+		env, _ := msg.StringArg("env")
+		assert.Equal(t, "staging", env)
+	}
+
+	// prefix handlers take precedence
+	cb.handleMessage(testMessageEvent("deploy please ship jarbas to prod"))
+	assert.Len(t, prefix.messages, 1)
+	assert.Len(t, regex.messages, 1)
+}
+
+func TestRegexHandlerMention(t *testing.T) {
+	cb := testBot(t)
+	cb.directory.setup(&slack.ConnectedEvent{
+		Info: &slack.Info{
+			User: &slack.UserDetails{ID: "UBOT"},
+		},
+	})
+
+	regex := &recordingHandler{name: "regex"}
+	cb.AddRegexHandler(regexp.MustCompile(`^deploy (?P<app>\w+)$`), regex, WithMention())
+
+	cb.handleMessage(testMessageEvent("deploy jarbas"))
+	assert.Len(t, regex.messages, 0)
+
+	cb.handleMessage(testMessageEvent("<@UBOT>: deploy jarbas"))
+	if assert.Len(t, regex.messages, 1) {
+		assert.Equal(t, "deploy jarbas", regex.messages[0].Match)
+		app, _ := regex.messages[0].StringArg("app")
+		assert.Equal(t, "jarbas", app)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	cb := testBot(t)
+	cb.directory.userIDToName["U123"] = "jarbas"
+
+	handler := &recordingHandler{name: "plain"}
+	cb.AddMessageHandler("check", handler)
+
+	cb.handleMessage(testMessageEvent("check <@U123> <@U999> <https://github.com/lxfontes/jarbas|the repo> <https://example.com>"))
+	if assert.Len(t, handler.messages, 1) {
+		assert.Equal(t, "check jarbas U999 the repo https://example.com", handler.messages[0].PlainText)
+	}
+}
+
+func TestIgnoreOwnMessages(t *testing.T) {
+	cb := testBot(t)
+	cb.directory.setup(&slack.ConnectedEvent{
+		Info: &slack.Info{
+			User: &slack.UserDetails{ID: "UBOT"},
+		},
+	})
+
+	handler := &recordingHandler{name: "echo"}
+	cb.AddMessageHandler("echo", handler)
+
+	ev := testMessageEvent("echo hello")
+	ev.User = "UBOT"
+	cb.handleMessage(ev)
+	assert.Len(t, handler.messages, 0)
+
+	cb.handleMessage(testMessageEvent("echo hello"))
+	assert.Len(t, handler.messages, 1)
+}
+
+func TestPrivateAndMentionOptions(t *testing.T) {
+	cb := testBot(t)
+	cb.directory.setup(&slack.ConnectedEvent{
+		Info: &slack.Info{
+			User: &slack.UserDetails{ID: "UBOT"},
+		},
+	})
+
+	private := &recordingHandler{name: "private"}
+	mention := &recordingHandler{name: "mention"}
+	cb.AddMessageHandler("secret", private, WithPrivateMessage())
+	cb.AddMessageHandler("deploy", mention, WithMention())
+
+	cb.handleMessage(testMessageEvent("secret stuff"))
+	assert.Len(t, private.messages, 0)
+
+	dm := testMessageEvent("secret stuff")
+	dm.Channel = "D123"
+	cb.handleMessage(dm)
+	if assert.Len(t, private.messages, 1) {
+		assert.True(t, private.messages[0].IsPrivate)
+	}
+
+	cb.handleMessage(testMessageEvent("deploy prod"))
+	assert.Len(t, mention.messages, 0)
+
+	cb.handleMessage(testMessageEvent("<@UBOT>: deploy prod"))
+	if assert.Len(t, mention.messages, 1) {
+		assert.True(t, mention.messages[0].IsMention)
+		assert.Equal(t, "prod", mention.messages[0].RawArgs)
+	}
+}
+
+func TestArgumentErrorsSkipHandler(t *testing.T) {
+	cb := testBot(t)
+
+	handler := &recordingHandler{name: "deploy"}
+	cb.AddMessageHandler("deploy", handler,
+		WithRequiredArg("app", "app to deploy"),
+	)
+
+	cb.handleMessage(testMessageEvent("deploy"))
+	cb.handleMessage(testMessageEvent("deploy jarbas extra"))
+	cb.handleMessage(testMessageEvent(`deploy "jarbas`))
+	assert.Len(t, handler.messages, 0)
+
+	cb.handleMessage(testMessageEvent("deploy jarbas"))
+	assert.Len(t, handler.messages, 1)
+}
+
+func TestMissingRequiredArgument(t *testing.T) {
+	spec := []chatArg{
+		{name: "app", required: true},
+		{name: "env", required: true},
+	}
+
+	msg := &ChatMessage{
+		Match:   "deploy",
+		RawArgs: "jarbas",
+		Args:    ChatArgs{},
+	}
+
+	err := parseArguments(spec, msg)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, `missing required argument "env" for command "deploy"`, err.Error())
+	}
+	assert.Equal(t, "jarbas", msg.Args["app"])
+}
+
+type stubAuthHandler struct {
+	authorized map[string]bool
+	roles      []string
+}
+
+func (sa *stubAuthHandler) Name() string {
+	return "stub"
+}
+
+func (sa *stubAuthHandler) Authorize(user *ChatUser, role string) (ChatExternalUser, error) {
+	sa.roles = append(sa.roles, role)
+	if !sa.authorized[user.ID()] {
+		return nil, ErrUserAuthNeeded
+	}
+
+	return NewChatExternalUser(user, "octocat", "42", "secret"), nil
+}
+
+func (sa *stubAuthHandler) Deauthorize(user *ChatUser) error {
+	delete(sa.authorized, user.ID())
+	return nil
+}
+
+func TestChatExternalUser(t *testing.T) {
+	cb := testBot(t)
+	cb.AddAuthHandler(&stubAuthHandler{authorized: map[string]bool{"U123": true}})
+
+	msg := &ChatMessage{Bot: cb, User: &ChatUser{id: "U123"}}
+	externalUser, err := msg.AuthUser("stub", "admin")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "stub", externalUser.Site())
+		assert.Equal(t, "octocat", externalUser.Name())
+		assert.Equal(t, "42", externalUser.ID())
+		assert.Equal(t, "secret", externalUser.Token())
+		assert.Equal(t, msg.User, externalUser.(*chatExternalUser).User())
+	}
+
+	msg.User = &ChatUser{id: "U456"}
+	_, err = msg.AuthUser("stub", "admin")
+	assert.Equal(t, ErrUserAuthNeeded, err)
+
+	_, err = msg.AuthUser("unknown", "admin")
+	assert.NotNil(t, err)
+}
+
+func TestWithAuth(t *testing.T) {
+	cb := testBot(t)
+
+	auth := &stubAuthHandler{authorized: map[string]bool{"U123": true}}
+	cb.AddAuthHandler(auth)
+
+	deploy := &recordingHandler{name: "deploy"}
+	cb.AddMessageHandler("deploy", deploy, WithAuth("stub", "admin"))
+
+	cb.handleMessage(testMessageEvent("deploy prod"))
+	if assert.Len(t, deploy.messages, 1) {
+		assert.Equal(t, "octocat", deploy.messages[0].ExternalUser.Name())
+	}
+	assert.Equal(t, []string{"admin"}, auth.roles)
+
+	stranger := testMessageEvent("deploy prod")
+	stranger.User = "U456"
+	cb.handleMessage(stranger)
+	assert.Len(t, deploy.messages, 1)
+
+	assert.Nil(t, deploy.messages[0].Deauthorize("stub"))
+	cb.handleMessage(testMessageEvent("deploy prod"))
+	assert.Len(t, deploy.messages, 1)
+
+	assert.NotNil(t, cb.DeauthorizeUser(cb.User("U123"), "unknown"))
+}
+
+// only knows how to authorize
+type authorizeOnlyHandler struct{}
+
+func (ao *authorizeOnlyHandler) Name() string {
+	return "authorize-only"
+}
+
+func (ao *authorizeOnlyHandler) Authorize(user *ChatUser, role string) (ChatExternalUser, error) {
+	return NewChatExternalUser(user, "octocat", "42", "secret"), nil
+}
+
+func TestDeauthorizeIsOptional(t *testing.T) {
+	cb := testBot(t)
+	assert.Nil(t, cb.AddAuthHandler(&authorizeOnlyHandler{}))
+
+	_, err := cb.AuthorizeUser(cb.User("U123"), "authorize-only", "admin")
+	assert.Nil(t, err)
+	assert.NotNil(t, cb.DeauthorizeUser(cb.User("U123"), "authorize-only"))
+}
+
+func TestWithStore(t *testing.T) {
+	s := store.NewMemoryStore()
+	cb, err := NewChatBot("", WithStore(s))
+	assert.Nil(t, err)
+	assert.Equal(t, s, cb.Store())
+}
+
+type failingHandler struct {
+	err error
+}
+
+func (fh *failingHandler) Name() string {
+	return "failing"
+}
+
+func (fh *failingHandler) OnChatMessage(msg *ChatMessage) error {
+	return fh.err
+}
+
+func TestErrorHandler(t *testing.T) {
+	cb := testBot(t)
+
+	boom := errors.New("boom")
+	cb.AddMessageHandler("fail", &failingHandler{err: boom})
+	cb.AddMessageHandler("auth", &failingHandler{err: ErrUserAuthNeeded})
+
+	var handlers []string
+	var errs []error
+	cb.SetErrorHandler(func(handler ChatHandler, err error) {
+		handlers = append(handlers, handler.Name())
+		errs = append(errs, err)
+	})
+
+	cb.handleMessage(testMessageEvent("fail now"))
+	assert.Equal(t, []string{"failing"}, handlers)
+	assert.Equal(t, []error{boom}, errs)
+
+	// asking for auth is not a failure
+	cb.handleMessage(testMessageEvent("auth please"))
+	assert.Len(t, errs, 1)
+}
+
+func TestKeepTypingStopsWhenDisconnected(t *testing.T) {
+	cb := testBot(t)
+
+	assert.Equal(t, ErrNotConnected, cb.SendTyping(&ChatChannel{id: "C123"}))
+
+	// must not wait on done when there is nobody to type to
+	cb.KeepTyping(&ChatChannel{id: "C123"}, make(chan struct{}))
+}
+
+func TestSendTypingWhileReconnecting(t *testing.T) {
+	cb := testBot(t)
+	cb.slackRTM = cb.slackAPI.NewRTM()
+
+	// more than the RTM's outgoing queue holds
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, ErrNotConnected, cb.SendTyping(&ChatChannel{id: "C123"}))
+	}
+
+	cb.KeepTyping(&ChatChannel{id: "C123"}, make(chan struct{}))
+}
+
+func TestDirectoryLookupOnMiss(t *testing.T) {
+	cb := testBot(t)
+
+	lookups := 0
+	cb.directory.lookupUser = func(id string) (*slack.User, error) {
+		lookups++
+		return &slack.User{ID: id, Name: "newhire"}, nil
+	}
+
+	name, ok := cb.directory.userForID("U999")
+	assert.True(t, ok)
+	assert.Equal(t, "newhire", name)
+
+	// cached from now on
+	cb.directory.userForID("U999")
+	assert.Equal(t, 1, lookups)
+
+	_, ok = cb.directory.channelForID("C999")
+	assert.False(t, ok)
+}
+
+func TestDirectoryLookupsOffEventLoop(t *testing.T) {
+	cb := testBot(t)
+
+	release := make(chan struct{})
+	cb.directory.lookupUser = func(id string) (*slack.User, error) {
+		<-release
+		return &slack.User{ID: id, Name: "slowpoke"}, nil
+	}
+
+	recorder := &recordingEventHandler{}
+	cb.AddEventHandler(EventReaction, recorder)
+
+	// a slow lookup must not hold up the events behind it
+	returned := make(chan struct{})
+	go func() {
+		ev := &slack.ReactionAddedEvent{User: "U999", Reaction: "tada"}
+		cb.handleEvent(slack.RTMEvent{Data: ev})
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("event loop blocked on a directory lookup")
+	}
+
+	close(release)
+	cb.handlers.Wait()
+
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	if assert.Len(t, recorder.events, 1) {
+		assert.Equal(t, "slowpoke", recorder.events[0].Data.(*ChatEventReaction).User.Name())
+	}
+}
+
+func TestDirectoryRemembersMisses(t *testing.T) {
+	cb := testBot(t)
+
+	lookups := 0
+	cb.directory.lookupChannel = func(id string) (*slack.Channel, error) {
+		lookups++
+		return nil, errOffline
+	}
+
+	for i := 0; i < 3; i++ {
+		_, ok := cb.directory.channelForID("C999")
+		assert.False(t, ok)
+	}
+	assert.Equal(t, 1, lookups)
+
+	// asked again once the miss expires
+	cb.directory.misses["channel:C999"] = time.Now().Add(-time.Second)
+	cb.directory.channelForID("C999")
+	assert.Equal(t, 2, lookups)
+
+	// a reconnect forgets misses
+	cb.directory.setup(&slack.ConnectedEvent{Info: &slack.Info{}})
+	cb.directory.channelForID("C999")
+	assert.Equal(t, 3, lookups)
+}
+
+func TestDirectoryFollowsWorkspaceEvents(t *testing.T) {
+	cb := testBot(t)
+
+	cb.handleEvent(slack.RTMEvent{Data: &slack.TeamJoinEvent{
+		User: slack.User{ID: "U999", Name: "newhire"},
+	}})
+	cb.handleEvent(slack.RTMEvent{Data: &slack.ChannelCreatedEvent{
+		Channel: slack.ChannelCreatedInfo{ID: "C999", Name: "incidents"},
+	}})
+
+	name, ok := cb.directory.userForID("U999")
+	assert.True(t, ok)
+	assert.Equal(t, "newhire", name)
+
+	name, ok = cb.directory.channelForID("C999")
+	assert.True(t, ok)
+	assert.Equal(t, "incidents", name)
+}
+
+func TestDirectoryByName(t *testing.T) {
+	cb := testBot(t)
+
+	cb.handleEvent(slack.RTMEvent{Data: &slack.TeamJoinEvent{
+		User: slack.User{ID: "U999", Name: "newhire"},
+	}})
+	cb.handleEvent(slack.RTMEvent{Data: &slack.ChannelCreatedEvent{
+		Channel: slack.ChannelCreatedInfo{ID: "C999", Name: "incidents"},
+	}})
+
+	user, ok := cb.UserByName("@newhire")
+	if assert.True(t, ok) {
+		assert.Equal(t, "U999", user.ID())
+	}
+
+	channel, ok := cb.ChannelByName("#incidents")
+	if assert.True(t, ok) {
+		assert.Equal(t, "C999", channel.ID())
+		assert.Equal(t, "incidents", channel.Name())
+	}
+
+	cb.handleEvent(slack.RTMEvent{Data: &slack.ChannelRenameEvent{
+		Channel: slack.ChannelRenameInfo{ID: "C999", Name: "outages"},
+	}})
+
+	_, ok = cb.ChannelByName("incidents")
+	assert.False(t, ok)
+
+	channel, ok = cb.ChannelByName("outages")
+	if assert.True(t, ok) {
+		assert.Equal(t, "C999", channel.ID())
+	}
+
+	// direct messages are named after the user, don't shadow channels with them
+	cb.directory.addIM("D999")
+	cb.directory.addChannel("D999", "newhire")
+	_, ok = cb.ChannelByName("newhire")
+	assert.False(t, ok)
+}
+
+func TestPassthrough(t *testing.T) {
+	cb := testBot(t)
+
+	audit := &recordingHandler{name: "audit"}
+	deploy := &recordingHandler{name: "deploy"}
+	shadowed := &recordingHandler{name: "shadowed"}
+	cb.AddMessageHandler("deploy prod", audit, WithPassthrough())
+	cb.AddMessageHandler("deploy", deploy)
+	cb.AddMessageHandler("dep", shadowed)
+
+	cb.handleMessage(testMessageEvent("deploy prod now"))
+	if assert.Len(t, audit.messages, 1) {
+		assert.Equal(t, "now", audit.messages[0].RawArgs)
+	}
+	if assert.Len(t, deploy.messages, 1) {
+		assert.Equal(t, "deploy", deploy.messages[0].Match)
+		assert.Equal(t, "prod now", deploy.messages[0].RawArgs)
+	}
+
+	// deploy didn't pass through
+	assert.Len(t, shadowed.messages, 0)
+}
+
+func TestMessageEditedEvent(t *testing.T) {
+	cb := testBot(t)
+
+	edits := &recordingEventHandler{}
+	cb.AddEventHandler(EventMessageEdited, edits)
+
+	messages := &recordingHandler{name: "messages"}
+	cb.AddMessageHandler("", messages)
+
+	edited := testMessageEvent("")
+	edited.SubType = "message_changed"
+	edited.SubMessage = &slack.Msg{
+		User:      "U123",
+		Text:      "deploy staging",
+		Timestamp: "1234.5678",
+	}
+	cb.handleEvent(slack.RTMEvent{Data: edited})
+	cb.handlers.Wait()
+
+	// edits are not new commands
+	assert.Len(t, messages.messages, 0)
+
+	if assert.Len(t, edits.events, 1) {
+		data := edits.events[0].Data.(*ChatEventMessageEdited)
+		assert.Equal(t, "deploy staging", data.Text)
+		assert.Equal(t, "1234.5678", data.Timestamp)
+		assert.Equal(t, "U123", data.User.ID())
+		assert.Equal(t, "C123", data.Channel.ID())
+	}
+}
+
+func TestMessageDeletedEvent(t *testing.T) {
+	cb := testBot(t)
+
+	deletes := &recordingEventHandler{}
+	cb.AddEventHandler(EventMessageDeleted, deletes)
+
+	deleted := testMessageEvent("")
+	deleted.SubType = "message_deleted"
+	deleted.DeletedTimestamp = "1234.5678"
+	cb.handleEvent(slack.RTMEvent{Data: deleted})
+	cb.handlers.Wait()
+
+	if assert.Len(t, deletes.events, 1) {
+		data := deletes.events[0].Data.(*ChatEventMessageDeleted)
+		assert.Equal(t, "1234.5678", data.Timestamp)
+		assert.Equal(t, "C123", data.Channel.ID())
+	}
+}
+
+type failingEventHandler struct{}
+
+func (fe *failingEventHandler) Name() string {
+	return "failing"
+}
+
+func (fe *failingEventHandler) OnChatEvent(ev *ChatEvent) error {
+	return errors.New("boom")
+}
+
+func TestEventHandlerErrorsDontStarveOthers(t *testing.T) {
+	cb := testBot(t)
+
+	recorder := &recordingEventHandler{}
+	cb.AddEventHandler(EventConnection, &failingEventHandler{})
+	cb.AddEventHandler(EventConnection, recorder)
+
+	cb.emitEvent(EventConnection, &ChatEventConnection{Connected: true})
+	assert.Len(t, recorder.events, 1)
+}
+
+func TestPrivateFromConversationType(t *testing.T) {
+	cb := testBot(t)
+
+	// ids that look like a DM but aren't one
+	general := slack.Channel{}
+	general.ID = "D0GENERAL"
+	general.Name = "general"
+	cb.directory.setup(&slack.ConnectedEvent{
+		Info: &slack.Info{
+			Channels: []slack.Channel{general},
+		},
+	})
+	cb.directory.lookupChannel = func(id string) (*slack.Channel, error) {
+		channel := &slack.Channel{}
+		channel.ID = id
+		channel.IsIM = id == "G0DM"
+		return channel, nil
+	}
+
+	assert.False(t, cb.directory.isPrivate("D0GENERAL"))
+	assert.True(t, cb.directory.isPrivate("G0DM"))
+	assert.False(t, cb.directory.isPrivate("C0OTHER"))
+
+	private := &recordingHandler{name: "private"}
+	cb.AddMessageHandler("secret", private, WithPrivateMessage())
+
+	noChannel := testMessageEvent("secret stuff")
+	noChannel.Channel = ""
+	cb.handleMessage(noChannel)
+	assert.Len(t, private.messages, 0)
+}
+
+func TestConnectionEvents(t *testing.T) {
+	cb := testBot(t)
+
+	connection := &recordingEventHandler{}
+	cb.AddEventHandler(EventConnection, connection)
+
+	boom := errors.New("boom")
+	cb.handleEvent(slack.RTMEvent{Data: &slack.DisconnectedEvent{Intentional: false}})
+	cb.handleEvent(slack.RTMEvent{Data: &slack.ConnectionErrorEvent{Attempt: 2, ErrorObj: boom}})
+	cb.handlers.Wait()
+
+	found := map[int]*ChatEventConnection{}
+	for _, ev := range connection.events {
+		data := ev.Data.(*ChatEventConnection)
+		found[data.Attempt] = data
+	}
+
+	if assert.Contains(t, found, 0) {
+		assert.False(t, found[0].Intentional)
+	}
+
+	if assert.Contains(t, found, 3) {
+		assert.Equal(t, boom, found[3].Err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), retryBackoff{}.delay(5))
+
+	rb := retryBackoff{min: time.Second, max: 10 * time.Second}
+	assert.Equal(t, time.Second, rb.delay(1))
+	assert.Equal(t, 2*time.Second, rb.delay(2))
+	assert.Equal(t, 8*time.Second, rb.delay(4))
+	assert.Equal(t, 10*time.Second, rb.delay(5))
+	assert.Equal(t, 10*time.Second, rb.delay(50))
+}
+
+func TestReplyNotAcked(t *testing.T) {
+	cb := testBot(t)
+	cr := &ChatReply{
+		Bot:    cb,
+		Target: &ChatChannel{id: "C123"},
+	}
+
+	_, err := cr.Permalink()
+	assert.Equal(t, ErrReplyNotAcked, err)
+	assert.Equal(t, ErrReplyNotAcked, cr.Update("new text"))
+	assert.Equal(t, ErrReplyNotAcked, cr.Delete())
+	assert.Equal(t, ErrReplyNotAcked, cr.AddReaction("white_check_mark"))
+	assert.Equal(t, ErrReplyNotAcked, cr.RemoveReaction("white_check_mark"))
+}
+
+func TestBroadcastCollectsErrors(t *testing.T) {
+	cb := testBot(t)
+	targets := []ChatTarget{
+		&ChatChannel{id: "C1"},
+		&ChatChannel{id: "C2"},
+	}
+
+	replies, err := cb.Broadcast(targets, "release %s", "v1")
+	assert.Equal(t, []*ChatReply{nil, nil}, replies)
+
+	be, ok := err.(*BroadcastError)
+	if assert.True(t, ok) {
+		assert.Equal(t, map[string]error{"C1": ErrNotConnected, "C2": ErrNotConnected}, be.Errors)
+		assert.Equal(t, "could not send to 2 targets: C1: not connected to slack; C2: not connected to slack", be.Error())
+	}
+
+	replies, err = cb.Broadcast(nil, "nobody")
+	assert.Nil(t, err)
+	assert.Len(t, replies, 0)
+}
+
+func TestSubscribePresence(t *testing.T) {
+	cb := testBot(t)
+
+	// remembered until we connect
+	cb.SubscribePresence([]string{"U2", "U1"})
+	cb.SubscribePresence([]string{"U1", "U3"})
+	assert.Equal(t, []string{"U1", "U2", "U3"}, cb.presenceSubscriptions())
+}
+
+// outgoingIDs waits for n tracked attempts, sorted
+func outgoingIDs(cb *ChatBot, n int) []int {
+	for {
+		ids := []int{}
+		cb.outgoingIDs.Range(func(k, v interface{}) bool {
+			ids = append(ids, k.(int))
+			return true
+		})
+
+		if len(ids) >= n {
+			sort.Ints(ids)
+			return ids
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type sendObserver struct {
+	nopObserver
+	sent map[string]int
+}
+
+func (so *sendObserver) MessageSent(result string) {
+	so.sent[result]++
+}
+
+func TestSendRetriesSendErrors(t *testing.T) {
+	cb := testBot(t)
+	WithSendRetry(3, time.Millisecond, time.Millisecond)(cb)
+	WithRateLimit(0, 0)(cb)
+	cb.slackRTM = cb.slackAPI.NewRTM()
+
+	// the first attempt never reaches the socket, the second one gets acked
+	go func() {
+		first := outgoingIDs(cb, 1)[0]
+		cb.handleSendError(first, errors.New("broken pipe"))
+
+		for {
+			ids := outgoingIDs(cb, 1)
+			if ids[0] != first {
+				cb.handleAck(&slack.AckMessage{ReplyTo: ids[0], Timestamp: "1111.2222"})
+				return
+			}
+		}
+	}()
+
+	cr, err := cb.Send(&ChatChannel{id: "C123"}, "", "hello")
+	if assert.Nil(t, err) {
+		assert.Equal(t, "1111.2222", cr.Timestamp)
+	}
+
+	// nothing left behind to be acked
+	remaining := 0
+	cb.outgoingIDs.Range(func(k, v interface{}) bool {
+		remaining++
+		return true
+	})
+	assert.Equal(t, 0, remaining)
+}
+
+func TestSendRetryGivesUp(t *testing.T) {
+	cb := testBot(t)
+	WithSendRetry(2, time.Millisecond, time.Millisecond)(cb)
+	WithRateLimit(0, 0)(cb)
+	cb.slackRTM = cb.slackAPI.NewRTM()
+
+	go func() {
+		first := outgoingIDs(cb, 1)[0]
+		cb.handleSendError(first, errors.New("broken pipe"))
+
+		for {
+			ids := outgoingIDs(cb, 1)
+			if ids[0] != first {
+				cb.handleSendError(ids[0], errors.New("still broken"))
+				return
+			}
+		}
+	}()
+
+	_, err := cb.Send(&ChatChannel{id: "C123"}, "", "hello")
+	assert.EqualError(t, err, "still broken")
+}
+
+func TestSendTooLongIsNotRetried(t *testing.T) {
+	observer := &sendObserver{sent: map[string]int{}}
+	cb := testBot(t)
+	WithObserver(observer)(cb)
+	WithSendRetry(3, time.Millisecond, time.Millisecond)(cb)
+	WithRateLimit(0, 0)(cb)
+	cb.slackRTM = cb.slackAPI.NewRTM()
+
+	go func() {
+		cb.handleSendError(outgoingIDs(cb, 1)[0], ErrMessageTooLong)
+	}()
+
+	_, err := cb.Send(&ChatChannel{id: "C123"}, "", "hello")
+	assert.Equal(t, ErrMessageTooLong, err)
+	assert.Equal(t, map[string]int{SendError: 1}, observer.sent)
+}
+
+func TestSendTimeoutIsNotRetried(t *testing.T) {
+	defer func(d time.Duration) { ackTimeout = d }(ackTimeout)
+	ackTimeout = 5 * time.Millisecond
+
+	observer := &sendObserver{sent: map[string]int{}}
+	cb := testBot(t)
+	WithObserver(observer)(cb)
+	WithSendRetry(3, time.Millisecond, time.Millisecond)(cb)
+	WithRateLimit(0, 0)(cb)
+	cb.slackRTM = cb.slackAPI.NewRTM()
+
+	// still queued, sending again would post it twice once reconnected
+	_, err := cb.Send(&ChatChannel{id: "C123"}, "", "hello")
+	assert.NotNil(t, err)
+	assert.Equal(t, map[string]int{SendTimeout: 1}, observer.sent)
+}
+
+func TestSendContextFullQueue(t *testing.T) {
+	cb := testBot(t)
+	WithRateLimit(0, 0)(cb)
+	cb.slackRTM = cb.slackAPI.NewRTM()
+
+	// never connected, nothing drains the rtm's outgoing buffer
+	for i := 0; i < 20; i++ {
+		cb.slackRTM.SendMessage(cb.slackRTM.NewOutgoingMessage("filler", "C123"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := cb.SendContext(ctx, &ChatChannel{id: "C123"}, "", "hello")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+type panickingHandler struct{}
+
+func (ph *panickingHandler) Name() string {
+	return "panicking"
+}
+
+func (ph *panickingHandler) OnChatMessage(msg *ChatMessage) error {
+	panic("boom")
+}
+
+func (ph *panickingHandler) OnChatEvent(ev *ChatEvent) error {
+	var m map[string]int
+	m["boom"]++
+	return nil
+}
+
+func TestHandlerPanicIsRecovered(t *testing.T) {
+	cb := testBot(t)
+
+	var handlerErr error
+	cb.SetErrorHandler(func(handler ChatHandler, err error) {
+		handlerErr = err
+	})
+
+	cb.AddMessageHandler("crash", &panickingHandler{})
+	cb.handleMessage(testMessageEvent("crash"))
+
+	if assert.NotNil(t, handlerErr) {
+		hp, ok := handlerErr.(*handlerPanic)
+		if assert.True(t, ok) {
+			assert.Equal(t, "boom", hp.value)
+			assert.NotEmpty(t, hp.stack)
+		}
+	}
+
+	// later event handlers still run
+	recorder := &recordingEventHandler{}
+	cb.AddEventHandler(EventPresence, &panickingHandler{})
+	cb.AddEventHandler(EventPresence, recorder)
+	cb.emitEvent(EventPresence, &ChatEventPresence{})
+	assert.Len(t, recorder.events, 1)
+}
+
+func TestBotMessages(t *testing.T) {
+	cb := testBot(t)
+
+	handler := &recordingHandler{name: "ping"}
+	cb.AddMessageHandler("ping", handler)
+
+	cb.handleMessage(testMessageEvent("ping"))
+
+	ev := testMessageEvent("ping")
+	ev.BotID = "B123"
+	cb.handleMessage(ev)
+
+	ev = testMessageEvent("ping")
+	ev.User = ""
+	ev.SubType = "bot_message"
+	cb.handleMessage(ev)
+
+	if assert.Len(t, handler.messages, 3) {
+		assert.False(t, handler.messages[0].IsBot)
+		assert.True(t, handler.messages[1].IsBot)
+		assert.True(t, handler.messages[2].IsBot)
+	}
+}
+
+func TestMessageTraceID(t *testing.T) {
+	cb := testBot(t)
+
+	handler := &recordingHandler{name: "ping"}
+	cb.AddMessageHandler("ping", handler)
+
+	cb.handleMessage(testMessageEvent("ping"))
+	cb.handleMessage(testMessageEvent("ping"))
+
+	if assert.Len(t, handler.messages, 2) {
+		first, second := handler.messages[0], handler.messages[1]
+		assert.NotEmpty(t, first.TraceID)
+		assert.NotEqual(t, first.TraceID, second.TraceID)
+		assert.Equal(t, first.TraceID, TraceID(first.Context()))
+	}
+
+	assert.Empty(t, TraceID(context.Background()))
+}
+
+func TestHandlerRegistrationWhileServing(t *testing.T) {
+	cb := testBot(t)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			cb.AddMessageHandler(fmt.Sprintf("cmd%d", i), &recordingHandler{name: "late"})
+			cb.AddRegexHandler(regexp.MustCompile(fmt.Sprintf("^re%d$", i)), &recordingHandler{name: "late"})
+			cb.AddEventHandler(EventPresence, &recordingEventHandler{})
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			cb.handleMessage(testMessageEvent(fmt.Sprintf("cmd%d", i)))
+			cb.emitEvent(EventPresence, &ChatEventPresence{})
+			cb.Patterns()
+		}
+	}()
+
+	wg.Wait()
+	cb.handlers.Wait()
+
+	assert.Contains(t, cb.Patterns(), "cmd49")
+}
+
+func TestRemoveHandlers(t *testing.T) {
+	cb := testBot(t)
+
+	first := &recordingHandler{name: "first"}
+	second := &recordingHandler{name: "second"}
+	cb.AddMessageHandler("ping", first, WithPassthrough())
+	cb.AddMessageHandler("ping", second)
+
+	assert.Nil(t, cb.RemoveMessageHandler("ping", first))
+	assert.NotNil(t, cb.RemoveMessageHandler("ping", first))
+	assert.NotNil(t, cb.RemoveMessageHandler("pong", second))
+
+	cb.handleMessage(testMessageEvent("ping"))
+	cb.handlers.Wait()
+	assert.Len(t, first.messages, 0)
+	assert.Len(t, second.messages, 1)
+
+	// the last handler takes the pattern along
+	assert.Nil(t, cb.RemoveMessageHandler("ping", second))
+	assert.NotContains(t, cb.Patterns(), "ping")
+
+	_, ok := cb.Usage("ping")
+	assert.False(t, ok)
+
+	recorder := &recordingEventHandler{}
+	cb.AddEventHandler(EventPresence, recorder)
+	cb.emitEvent(EventPresence, &ChatEventPresence{})
+
+	assert.NotNil(t, cb.RemoveEventHandler(EventReaction, recorder))
+	assert.Nil(t, cb.RemoveEventHandler(EventPresence, recorder))
+	assert.NotNil(t, cb.RemoveEventHandler(EventPresence, recorder))
+	cb.emitEvent(EventPresence, &ChatEventPresence{})
+	assert.Len(t, recorder.events, 1)
+}
+
+func TestRemoveRegexHandler(t *testing.T) {
+	cb := testBot(t)
+
+	handler := &recordingHandler{name: "deploy"}
+	other := &recordingHandler{name: "other"}
+	cb.AddRegexHandler(regexp.MustCompile(`^deploy (?P<app>\w+)`), handler)
+
+	assert.NotNil(t, cb.RemoveRegexHandler(regexp.MustCompile(`^deploy (?P<app>\w+)`), other))
+	assert.NotNil(t, cb.RemoveRegexHandler(regexp.MustCompile(`^ship`), handler))
+
+	// recompiled patterns match by source
+	assert.Nil(t, cb.RemoveRegexHandler(regexp.MustCompile(`^deploy (?P<app>\w+)`), handler))
+
+	cb.handleMessage(testMessageEvent("deploy api"))
+	cb.handlers.Wait()
+	assert.Len(t, handler.messages, 0)
+}
+
+func TestRemoveSameHandlerTwice(t *testing.T) {
+	cb := testBot(t)
+
+	handler := &recordingHandler{name: "twice"}
+	cb.AddMessageHandler("ping", handler, WithPassthrough())
+	cb.AddMessageHandler("ping", handler)
+
+	// one registration at a time
+	assert.Nil(t, cb.RemoveMessageHandler("ping", handler))
+	cb.handleMessage(testMessageEvent("ping"))
+	cb.handlers.Wait()
+	assert.Len(t, handler.messages, 1)
+
+	assert.Nil(t, cb.RemoveMessageHandler("ping", handler))
+	assert.NotContains(t, cb.Patterns(), "ping")
+}
+
+// handlers that can't be compared with ==, like func types
+type funcHandler func(msg *ChatMessage) error
+
+func (fh funcHandler) Name() string                         { return "func" }
+func (fh funcHandler) OnChatMessage(msg *ChatMessage) error { return fh(msg) }
+
+func TestRemoveUncomparableHandler(t *testing.T) {
+	cb := testBot(t)
+
+	calls := 0
+	handler := funcHandler(func(msg *ChatMessage) error {
+		calls++
+		return nil
+	})
+	cb.AddMessageHandler("ping", handler)
+	cb.AddMessageHandler("ping", &recordingHandler{name: "pointer"}, WithPassthrough())
+
+	// an error instead of a panic, the handler keeps running
+	assert.Equal(t, errUncomparableHandler, cb.RemoveMessageHandler("ping", handler))
+	assert.Equal(t, errUncomparableHandler, cb.RemoveRegexHandler(regexp.MustCompile("ping"), handler))
+
+	cb.handleMessage(testMessageEvent("ping"))
+	cb.handlers.Wait()
+	assert.Equal(t, 1, calls)
+}
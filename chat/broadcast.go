@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BroadcastError reports the targets Broadcast could not reach, indexed by target id
+type BroadcastError struct {
+	Errors map[string]error
+}
+
+func (be *BroadcastError) Error() string {
+	ids := []string{}
+	for id := range be.Errors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	failures := []string{}
+	for _, id := range ids {
+		failures = append(failures, fmt.Sprintf("%s: %s", id, be.Errors[id]))
+	}
+
+	return fmt.Sprintf("could not send to %d targets: %s", len(ids), strings.Join(failures, "; "))
+}
+
+// Broadcast sends to all targets concurrently. Replies line up with targets, nil where sending failed,
+// and failures are collected in a *BroadcastError.
+func (cb *ChatBot) Broadcast(targets []ChatTarget, s string, args ...interface{}) ([]*ChatReply, error) {
+	text := fmt.Sprintf(s, args...)
+	replies := make([]*ChatReply, len(targets))
+	errs := map[string]error{}
+
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target ChatTarget) {
+			defer wg.Done()
+
+			cr, err := cb.send(context.Background(), target, "", false, text)
+			if err != nil {
+				mtx.Lock()
+				errs[target.ID()] = err
+				mtx.Unlock()
+				return
+			}
+
+			replies[i] = cr
+		}(i, target)
+	}
+
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return replies, &BroadcastError{Errors: errs}
+	}
+
+	return replies, nil
+}
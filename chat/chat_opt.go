@@ -1,10 +1,38 @@
 package chat
 
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
 type chatAction struct {
-	handler ChatMessageHandler
-	private bool
-	mention bool
-	args    []chatArg
+	handler     ChatMessageHandler
+	private     bool
+	mention     bool
+	args        []chatArg
+	description string
+	authSite    string
+	authRole    string
+	passthrough bool
+}
+
+// accepts enforces WithPrivateMessage & WithMention, direct messages count as mentions
+func (ca *chatAction) accepts(isPrivate bool, isMention bool) bool {
+	if ca.private && !isPrivate {
+		return false
+	}
+
+	if ca.mention && !isMention && !isPrivate {
+		return false
+	}
+
+	return true
+}
+
+type regexAction struct {
+	re     *regexp.Regexp
+	action *chatAction
 }
 
 type chatOpt func(*chatAction)
@@ -21,6 +49,30 @@ func WithPrivateMessage() chatOpt {
 	}
 }
 
+// WithDescription is shown by the help command
+func WithDescription(description string) chatOpt {
+	return func(ca *chatAction) {
+		ca.description = description
+	}
+}
+
+// WithPassthrough lets handlers for shorter patterns, regex and default handlers
+// run after this one, ex: an audit logger in front of the real command
+func WithPassthrough() chatOpt {
+	return func(ca *chatAction) {
+		ca.passthrough = true
+	}
+}
+
+// WithAuth authorizes the user against site before invoking the handler,
+// the result is available as ChatMessage.ExternalUser
+func WithAuth(site string, role string) chatOpt {
+	return func(ca *chatAction) {
+		ca.authSite = site
+		ca.authRole = role
+	}
+}
+
 func WithOptionalArg(param string, defValue string, description string) chatOpt {
 	return func(ca *chatAction) {
 		arg := chatArg{
@@ -33,6 +85,32 @@ func WithOptionalArg(param string, defValue string, description string) chatOpt
 	}
 }
 
+// WithOptionalIntArg only accepts integers, IntArg returns def when the arg is omitted
+func WithOptionalIntArg(param string, def int, description string) chatOpt {
+	return withTypedArg(param, argInt, strconv.Itoa(def), description)
+}
+
+func WithOptionalFloatArg(param string, def float64, description string) chatOpt {
+	return withTypedArg(param, argFloat, strconv.FormatFloat(def, 'g', -1, 64), description)
+}
+
+func WithOptionalDurationArg(param string, def time.Duration, description string) chatOpt {
+	return withTypedArg(param, argDuration, def.String(), description)
+}
+
+func withTypedArg(param string, kind argKind, defValue string, description string) chatOpt {
+	return func(ca *chatAction) {
+		arg := chatArg{
+			name:        param,
+			defValue:    defValue,
+			description: description,
+			kind:        kind,
+		}
+
+		ca.args = append(ca.args, arg)
+	}
+}
+
 func WithRequiredArg(param string, description string) chatOpt {
 	return func(ca *chatAction) {
 		arg := chatArg{
@@ -44,3 +122,45 @@ func WithRequiredArg(param string, description string) chatOpt {
 		ca.args = append(ca.args, arg)
 	}
 }
+
+// WithChoiceArg is a required argument, rejected unless it is one of choices
+func WithChoiceArg(param string, choices []string, description string) chatOpt {
+	return func(ca *chatAction) {
+		arg := chatArg{
+			name:        param,
+			required:    true,
+			description: description,
+			choices:     choices,
+		}
+
+		ca.args = append(ca.args, arg)
+	}
+}
+
+// WithListArg collects every occurrence, ex: 'notify user=alice user=bob' or 'notify alice bob'.
+// Values are available through ListArg, not Args.
+func WithListArg(param string, description string) chatOpt {
+	return func(ca *chatAction) {
+		arg := chatArg{
+			name:        param,
+			description: description,
+			list:        true,
+		}
+
+		ca.args = append(ca.args, arg)
+	}
+}
+
+// WithFlagArg is true when '--param' is present
+func WithFlagArg(param string, description string) chatOpt {
+	return func(ca *chatAction) {
+		arg := chatArg{
+			name:        param,
+			flag:        true,
+			defValue:    "false",
+			description: description,
+		}
+
+		ca.args = append(ca.args, arg)
+	}
+}
@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type health struct {
+	connected bool
+	latency   time.Duration // from the last latency report
+}
+
+// Connected is true while the RTM connection is up
+func (cb *ChatBot) Connected() bool {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+
+	return cb.health.connected
+}
+
+func (cb *ChatBot) setConnected(connected bool) {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+
+	cb.health.connected = connected
+}
+
+func (cb *ChatBot) setLatency(latency time.Duration) {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+
+	cb.health.latency = latency
+}
+
+// HealthHandler answers 200 while connected and slack's latency stays under maxLatency,
+// 503 otherwise. A maxLatency of 0 skips the latency check.
+func (cb *ChatBot) HealthHandler(maxLatency time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cb.mtx.Lock()
+		h := cb.health
+		cb.mtx.Unlock()
+
+		switch {
+		case !h.connected:
+			http.Error(w, "not connected", http.StatusServiceUnavailable)
+		case maxLatency > 0 && h.latency > maxLatency:
+			http.Error(w, fmt.Sprintf("latency %s over %s", h.latency, maxLatency), http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintf(w, "ok, latency %s\n", h.latency)
+		}
+	})
+}
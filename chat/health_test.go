@@ -0,0 +1,36 @@
+package chat
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func healthStatus(cb *ChatBot, maxLatency time.Duration) int {
+	w := httptest.NewRecorder()
+	cb.HealthHandler(maxLatency).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	return w.Code
+}
+
+func TestHealth(t *testing.T) {
+	cb := testBot(t)
+	assert.False(t, cb.Connected())
+	assert.Equal(t, http.StatusServiceUnavailable, healthStatus(cb, 0))
+
+	cb.handleEvent(slack.RTMEvent{Data: &slack.ConnectedEvent{Info: &slack.Info{}}})
+	assert.True(t, cb.Connected())
+	assert.Equal(t, http.StatusOK, healthStatus(cb, 0))
+
+	cb.handleEvent(slack.RTMEvent{Data: &slack.LatencyReport{Value: 2 * time.Second}})
+	assert.Equal(t, http.StatusOK, healthStatus(cb, 5*time.Second))
+	assert.Equal(t, http.StatusServiceUnavailable, healthStatus(cb, time.Second))
+
+	cb.handleEvent(slack.RTMEvent{Data: &slack.DisconnectedEvent{}})
+	assert.False(t, cb.Connected())
+
+	cb.handlers.Wait()
+}
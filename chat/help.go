@@ -0,0 +1,117 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	helpPattern = "help"
+)
+
+type helpHandler struct {
+}
+
+var _ ChatMessageHandler = &helpHandler{}
+
+func (hh *helpHandler) Name() string {
+	return "help"
+}
+
+// OnChatMessage handles 'help' and 'help <pattern>'
+func (hh *helpHandler) OnChatMessage(msg *ChatMessage) error {
+	if msg.RawArgs == "" {
+		_, err := msg.ReplyInThread("%s", msg.Bot.helpSummary())
+		return err
+	}
+
+	text, ok := msg.Bot.Usage(msg.RawArgs)
+	if !ok {
+		_, err := msg.ReplyInThread("unknown command `%s`, try `%s`", msg.RawArgs, helpPattern)
+		return err
+	}
+
+	_, err := msg.ReplyInThread("%s", text)
+	return err
+}
+
+func (cb *ChatBot) helpSummary() string {
+	lines := []string{"available commands:"}
+	for _, pattern := range cb.Patterns() {
+		line := fmt.Sprintf("`%s`", pattern)
+		actions, _ := cb.actionsFor(pattern)
+		for _, ca := range actions {
+			if ca.description != "" {
+				line = fmt.Sprintf("%s - %s", line, ca.description)
+				break
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Usage renders the arguments and description of every handler registered for pattern,
+// as shown by 'help <pattern>' and when arguments can't be parsed
+func (cb *ChatBot) Usage(pattern string) (string, bool) {
+	actions, ok := cb.actionsFor(pattern)
+	if !ok {
+		return "", false
+	}
+
+	lines := []string{}
+	for _, ca := range actions {
+		usage := fmt.Sprintf("`%s", pattern)
+		for _, arg := range ca.args {
+			if arg.flag {
+				usage = fmt.Sprintf("%s [%s%s]", usage, flagPrefix, arg.name)
+			} else if arg.list {
+				usage = fmt.Sprintf("%s [%s...]", usage, arg.name)
+			} else if arg.required {
+				usage = fmt.Sprintf("%s <%s>", usage, arg.name)
+			} else {
+				usage = fmt.Sprintf("%s [%s]", usage, arg.name)
+			}
+		}
+		lines = append(lines, usage+"`")
+
+		if ca.description != "" {
+			lines = append(lines, ca.description)
+		}
+
+		for _, arg := range ca.args {
+			lines = append(lines, "  "+arg.help())
+		}
+	}
+
+	return strings.Join(lines, "\n"), true
+}
+
+func (arg *chatArg) help() string {
+	if arg.flag {
+		return fmt.Sprintf("`%s%s` (flag) %s", flagPrefix, arg.name, arg.description)
+	}
+
+	if arg.list {
+		return fmt.Sprintf("`%s` (repeatable) %s", arg.name, arg.description)
+	}
+
+	if len(arg.choices) > 0 {
+		return fmt.Sprintf("`%s` (one of `%s`) %s", arg.name, strings.Join(arg.choices, "`, `"), arg.description)
+	}
+
+	if arg.required {
+		return fmt.Sprintf("`%s` (required) %s", arg.name, arg.description)
+	}
+
+	return fmt.Sprintf("`%s` (optional, default `%s`) %s", arg.name, arg.defValue, arg.description)
+}
+
+func (cb *ChatBot) actionsFor(pattern string) ([]*chatAction, bool) {
+	cb.handlersMtx.RLock()
+	defer cb.handlersMtx.RUnlock()
+
+	actions, ok := cb.chatHandlers[pattern]
+	return actions, ok
+}
@@ -0,0 +1,33 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelp(t *testing.T) {
+	cb := testBot(t)
+
+	cb.AddMessageHandler("deploy", &recordingHandler{name: "deploy"},
+		WithDescription("ships an app"),
+		WithRequiredArg("app", "app to deploy"),
+		WithOptionalArg("env", "staging", "target environment"),
+	)
+	cb.AddMessageHandler("ping", &recordingHandler{name: "ping"})
+
+	assert.Equal(t, []string{"deploy", "help", "ping"}, cb.Patterns())
+
+	summary := cb.helpSummary()
+	assert.Contains(t, summary, "`deploy` - ships an app")
+	assert.Contains(t, summary, "`ping`")
+
+	text, ok := cb.Usage("deploy")
+	assert.True(t, ok)
+	assert.Contains(t, text, "`deploy <app> [env]`")
+	assert.Contains(t, text, "`app` (required) app to deploy")
+	assert.Contains(t, text, "`env` (optional, default `staging`) target environment")
+
+	_, ok = cb.Usage("nope")
+	assert.False(t, ok)
+}
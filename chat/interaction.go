@@ -0,0 +1,165 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/lxfontes/jarbas/logger"
+	"github.com/nlopes/slack"
+)
+
+// slack payloads are small, anything bigger is not from slack
+const maxInteractionBody = 1 << 20
+
+// ChatInteraction is a click on a button (or menu) we posted with SendAttachments
+type ChatInteraction struct {
+	Bot         *ChatBot
+	CallbackID  string // from the attachment holding the button
+	Action      string // button name
+	Value       string // button value
+	User        *ChatUser
+	Channel     ChatTarget
+	Timestamp   string // of the message holding the button
+	ResponseURL string
+	Logger      logger.Log
+
+	Callback slack.InteractionCallback
+}
+
+type ChatInteractionHandler interface {
+	ChatHandler
+	OnInteraction(action *ChatInteraction) error
+}
+
+// Update replaces the message holding the button, ex: to drop buttons once clicked
+func (ci *ChatInteraction) Update(s string, args ...interface{}) (string, error) {
+	return ci.Bot.UpdateMessage(ci.Channel, ci.Timestamp, s, args...)
+}
+
+func (ci *ChatInteraction) ReplyInThread(s string, args ...interface{}) (*ChatReply, error) {
+	return ci.Bot.Send(ci.Channel, ci.Timestamp, s, args...)
+}
+
+// AddInteractionHandler routes clicks on attachments with callbackID to handler
+func (cb *ChatBot) AddInteractionHandler(callbackID string, handler ChatInteractionHandler) error {
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	if _, ok := cb.interactionHandlers[callbackID]; ok {
+		return errors.New("callback id already present")
+	}
+
+	cb.interactionHandlers[callbackID] = handler
+	return nil
+}
+
+// InteractionHandler serves the slack app's interactivity url, requests are checked against signingSecret.
+// Handlers run in the background since slack expects an answer within 3 seconds.
+func (cb *ChatBot) InteractionHandler(signingSecret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxInteractionBody))
+		if err != nil {
+			http.Error(w, "could not read body", http.StatusBadRequest)
+			return
+		}
+
+		if err := verifySignature(r.Header, body, signingSecret); err != nil {
+			cb.Logger().WithError(err).Warning("rejected interaction")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		var callback slack.InteractionCallback
+		if err := json.Unmarshal([]byte(form.Get("payload")), &callback); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		cb.handlersMtx.RLock()
+		handler, ok := cb.interactionHandlers[callback.CallbackID]
+		cb.handlersMtx.RUnlock()
+		if !ok {
+			cb.Logger().WithField("callback_id", callback.CallbackID).Warning("no handler for interaction")
+			http.NotFound(w, r)
+			return
+		}
+
+		spawned := cb.spawnUnlessDraining(func() {
+			// directory lookups can hit the web api, slack only waits 3 seconds for our answer
+			action := cb.interactionFor(callback)
+			cb.observer.HandlerInvoked(handler.Name())
+
+			if err := callInteractionHandler(handler, action); err != nil {
+				cb.observer.HandlerFailed(handler.Name())
+				withStack(action.Logger, err).WithError(err).Error("interaction handler failed")
+			}
+		})
+		if !spawned {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func callInteractionHandler(handler ChatInteractionHandler, action *ChatInteraction) (err error) {
+	defer recoverHandler(&err)
+	return handler.OnInteraction(action)
+}
+
+func (cb *ChatBot) interactionFor(callback slack.InteractionCallback) *ChatInteraction {
+	userName, _ := cb.directory.userForID(callback.User.ID)
+	channelName, _ := cb.directory.channelForID(callback.Channel.ID)
+
+	action := &ChatInteraction{
+		Bot:        cb,
+		CallbackID: callback.CallbackID,
+		User:       cb.userFor(callback.User.ID, userName),
+		Channel: &ChatChannel{
+			id:   callback.Channel.ID,
+			name: channelName,
+		},
+		Timestamp:   callback.MessageTs,
+		ResponseURL: callback.ResponseURL,
+		Callback:    callback,
+	}
+
+	// buttons send a single action
+	if len(callback.Actions) > 0 {
+		action.Action = callback.Actions[0].Name
+		action.Value = callback.Actions[0].Value
+	}
+
+	action.Logger = cb.Logger().
+		WithField("callback_id", action.CallbackID).
+		WithField("action", action.Action).
+		WithField("user", action.User.ID())
+
+	return action
+}
+
+// verifySignature checks slack's X-Slack-Signature header, see https://api.slack.com/authentication/verifying-requests-from-slack
+func verifySignature(header http.Header, body []byte, signingSecret string) error {
+	sv, err := slack.NewSecretsVerifier(header, signingSecret)
+	if err != nil {
+		return err
+	}
+
+	if _, err := sv.Write(body); err != nil {
+		return err
+	}
+
+	return sv.Ensure()
+}
@@ -0,0 +1,150 @@
+package chat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSigningSecret = "shhh"
+
+type recordingInteractionHandler struct {
+	actions []*ChatInteraction
+}
+
+func (rih *recordingInteractionHandler) Name() string {
+	return "recording"
+}
+
+func (rih *recordingInteractionHandler) OnInteraction(action *ChatInteraction) error {
+	rih.actions = append(rih.actions, action)
+	return nil
+}
+
+func testInteractionRequest(secret string, payload string) *http.Request {
+	return testSignedRequest(secret, "/slack/interactions", url.Values{"payload": {payload}})
+}
+
+// testSignedRequest posts form the way slack does, signed with secret
+func testSignedRequest(secret string, path string, form url.Values) *http.Request {
+	body := form.Encode()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	r := httptest.NewRequest("POST", path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+const testInteractionPayload = `{
+	"type": "interactive_message",
+	"callback_id": "deploy",
+	"user": {"id": "U123"},
+	"channel": {"id": "C123"},
+	"message_ts": "1234.5678",
+	"actions": [{"name": "approve", "type": "button", "value": "v1.2"}]
+}`
+
+func TestInteractionHandler(t *testing.T) {
+	cb := testBot(t)
+	handler := &recordingInteractionHandler{}
+	assert.Nil(t, cb.AddInteractionHandler("deploy", handler))
+	assert.NotNil(t, cb.AddInteractionHandler("deploy", handler))
+
+	rr := httptest.NewRecorder()
+	cb.InteractionHandler(testSigningSecret).ServeHTTP(rr, testInteractionRequest(testSigningSecret, testInteractionPayload))
+	cb.handlers.Wait()
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	if assert.Len(t, handler.actions, 1) {
+		action := handler.actions[0]
+		assert.Equal(t, "deploy", action.CallbackID)
+		assert.Equal(t, "approve", action.Action)
+		assert.Equal(t, "v1.2", action.Value)
+		assert.Equal(t, "U123", action.User.ID())
+		assert.Equal(t, "C123", action.Channel.ID())
+		assert.Equal(t, "1234.5678", action.Timestamp)
+	}
+}
+
+func TestInteractionHandlerAnswersBeforeLookups(t *testing.T) {
+	cb := testBot(t)
+	handler := &recordingInteractionHandler{}
+	cb.AddInteractionHandler("deploy", handler)
+
+	release := make(chan struct{})
+	cb.directory.lookupUser = func(id string) (*slack.User, error) {
+		<-release
+		return &slack.User{ID: id, Name: "slowpoke"}, nil
+	}
+
+	answered := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		cb.InteractionHandler(testSigningSecret).ServeHTTP(rr, testInteractionRequest(testSigningSecret, testInteractionPayload))
+		answered <- rr.Code
+	}()
+
+	select {
+	case code := <-answered:
+		assert.Equal(t, http.StatusOK, code)
+	case <-time.After(time.Second):
+		t.Fatal("answer waited on a directory lookup")
+	}
+
+	close(release)
+	cb.handlers.Wait()
+	if assert.Len(t, handler.actions, 1) {
+		assert.Equal(t, "slowpoke", handler.actions[0].User.Name())
+	}
+}
+
+func TestInteractionHandlerRejects(t *testing.T) {
+	cb := testBot(t)
+	handler := &recordingInteractionHandler{}
+	cb.AddInteractionHandler("deploy", handler)
+
+	rr := httptest.NewRecorder()
+	cb.InteractionHandler(testSigningSecret).ServeHTTP(rr, testInteractionRequest("wrong", testInteractionPayload))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = httptest.NewRecorder()
+	unknown := strings.Replace(testInteractionPayload, "deploy", "rollback", 1)
+	cb.InteractionHandler(testSigningSecret).ServeHTTP(rr, testInteractionRequest(testSigningSecret, unknown))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	cb.handlers.Wait()
+	assert.Len(t, handler.actions, 0)
+}
+
+func TestInteractionHandlerWhileDraining(t *testing.T) {
+	cb := testBot(t)
+	handler := &recordingInteractionHandler{}
+	cb.AddInteractionHandler("deploy", handler)
+
+	cb.mtx.Lock()
+	cb.draining = true
+	cb.mtx.Unlock()
+
+	rr := httptest.NewRecorder()
+	cb.InteractionHandler(testSigningSecret).ServeHTTP(rr, testInteractionRequest(testSigningSecret, testInteractionPayload))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	cb.handlers.Wait()
+	assert.Len(t, handler.actions, 0)
+}
@@ -1,9 +1,12 @@
 package chat
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/lxfontes/jarbas/logger"
+	"github.com/nlopes/slack"
 )
 
 type ChatMessage struct {
@@ -14,22 +17,51 @@ type ChatMessage struct {
 	Args            ChatArgs
 	ThreadTimestamp string
 	Logger          logger.Log
+	TraceID         string // logged with the incoming message and replies, see Context
 
 	Match     string
 	Text      string
 	PlainText string
 	RawArgs   string
 	IsPrivate bool
+	IsMention bool // the bot was @-mentioned
+	IsBot     bool // sent by another bot or integration, skip these to avoid bot-to-bot loops
+
+	ExternalUser ChatExternalUser // set for handlers registered WithAuth
+
+	ListArgs map[string][]string // see WithListArg
 }
 
 func (cm *ChatMessage) StringArg(arg string) (string, bool) {
 	return cm.Args.String(arg)
 }
 
+// ListArg returns every value given for a WithListArg argument, in order
+func (cm *ChatMessage) ListArg(arg string) ([]string, bool) {
+	values, ok := cm.ListArgs[arg]
+	return values, ok
+}
+
+func (cm *ChatMessage) addListArg(arg string, value string) {
+	if cm.ListArgs == nil {
+		cm.ListArgs = map[string][]string{}
+	}
+
+	cm.ListArgs[arg] = append(cm.ListArgs[arg], value)
+}
+
 func (cm *ChatMessage) IntArg(arg string) (int, bool) {
 	return cm.Args.Int(arg)
 }
 
+func (cm *ChatMessage) FloatArg(arg string) (float64, bool) {
+	return cm.Args.Float(arg)
+}
+
+func (cm *ChatMessage) DurationArg(arg string) (time.Duration, bool) {
+	return cm.Args.Duration(arg)
+}
+
 func (cm *ChatMessage) InclusionArg(arg string, vals ...string) (string, bool) {
 	return cm.Args.Inclusion(arg, vals...)
 }
@@ -42,20 +74,48 @@ func (cm *ChatMessage) ReplyInThread(s string, args ...interface{}) (*ChatReply,
 		thread = cm.ThreadTimestamp
 	}
 
-	return cm.Bot.Send(cm.Channel, thread, s, args...)
+	return cm.Bot.send(cm.Context(), cm.Channel, thread, false, fmt.Sprintf(s, args...))
+}
+
+// ReplyInThreadBroadcast replies in the thread and also posts the reply to the channel
+func (cm *ChatMessage) ReplyInThreadBroadcast(s string, args ...interface{}) (*ChatReply, error) {
+	thread := cm.Timestamp
+	if cm.ThreadTimestamp != "" {
+		thread = cm.ThreadTimestamp
+	}
+
+	return cm.Bot.send(cm.Context(), cm.Channel, thread, true, fmt.Sprintf(s, args...))
+}
+
+// Context carries TraceID, use it with SendContext when replying from another goroutine
+func (cm *ChatMessage) Context() context.Context {
+	return WithTraceID(context.Background(), cm.TraceID)
 }
 
 func (cm *ChatMessage) Reply(s string, args ...interface{}) (*ChatReply, error) {
-	return cm.Bot.Send(cm.Channel, "", s, args...)
+	return cm.Bot.send(cm.Context(), cm.Channel, "", false, fmt.Sprintf(s, args...))
 }
 
 func (cm *ChatMessage) ReplyWithMention(s string, args ...interface{}) (*ChatReply, error) {
 	combined := fmt.Sprintf("<@%s> %s", cm.User.ID(), s)
-	return cm.Bot.Send(cm.Channel, "", combined, args...)
+	return cm.Bot.send(cm.Context(), cm.Channel, "", false, fmt.Sprintf(combined, args...))
 }
 
 func (cm *ChatMessage) ReplyPrivately(s string, args ...interface{}) (*ChatReply, error) {
-	return cm.Bot.SendPrivately(cm.User, "", s, args...)
+	return cm.Bot.sendPrivately(cm.Context(), cm.User, "", fmt.Sprintf(s, args...))
+}
+
+// ReplyEphemeral is only visible to the user who sent msg, no DM channel needed
+func (cm *ChatMessage) ReplyEphemeral(s string, args ...interface{}) (*ChatReply, error) {
+	return cm.Bot.SendEphemeral(cm.Channel, cm.User, cm.ThreadTimestamp, s, args...)
+}
+
+func (cm *ChatMessage) ReplyWithAttachments(text string, attachments ...slack.Attachment) (*ChatReply, error) {
+	return cm.Bot.SendAttachments(cm.Channel, "", text, attachments...)
+}
+
+func (cm *ChatMessage) ReplyWithFile(filename string, content []byte, opts ...uploadOpt) (*ChatReply, error) {
+	return cm.Bot.UploadFile(cm.Channel, filename, content, opts...)
 }
 
 func (cm *ChatMessage) AddReaction(reaction string) error {
@@ -69,3 +129,12 @@ func (cm *ChatMessage) RemoveReaction(reaction string) error {
 func (cm *ChatMessage) AuthorizeUser(site string, role string) (ChatExternalUser, error) {
 	return cm.Bot.AuthorizeUser(cm.User, site, role)
 }
+
+// AuthUser is AuthorizeUser, for handlers written against the older name
+func (cm *ChatMessage) AuthUser(site string, role string) (ChatExternalUser, error) {
+	return cm.AuthorizeUser(site, role)
+}
+
+func (cm *ChatMessage) Deauthorize(site string) error {
+	return cm.Bot.DeauthorizeUser(cm.User, site)
+}
@@ -0,0 +1,30 @@
+package chat
+
+const (
+	SendOK      = "ok"
+	SendTimeout = "timeout"
+	SendError   = "error"
+)
+
+// Observer is told about what the bot is doing, see the metrics package
+type Observer interface {
+	MessageReceived()
+	HandlerInvoked(handler string)
+	HandlerFailed(handler string)
+	// MessageSent reports SendOK, SendTimeout or SendError
+	MessageSent(result string)
+}
+
+type nopObserver struct{}
+
+func (no nopObserver) MessageReceived()              {}
+func (no nopObserver) HandlerInvoked(handler string) {}
+func (no nopObserver) HandlerFailed(handler string)  {}
+func (no nopObserver) MessageSent(result string)     {}
+
+// WithObserver reports bot activity to o
+func WithObserver(o Observer) botOpt {
+	return func(cb *ChatBot) {
+		cb.observer = o
+	}
+}
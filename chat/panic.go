@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/lxfontes/jarbas/logger"
+)
+
+// handlerPanic is returned in place of a handler's error when it panics
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (hp *handlerPanic) Error() string {
+	return fmt.Sprintf("handler panicked: %v", hp.value)
+}
+
+// recoverHandler keeps a panicking handler from taking the bot down,
+// defer it with a pointer to the handler's error
+func recoverHandler(err *error) {
+	if r := recover(); r != nil {
+		*err = &handlerPanic{value: r, stack: debug.Stack()}
+	}
+}
+
+// withStack adds the panic's stack trace, when err is one
+func withStack(ll logger.Log, err error) logger.Log {
+	if hp, ok := err.(*handlerPanic); ok {
+		return ll.WithField("stack", string(hp.stack))
+	}
+
+	return ll
+}
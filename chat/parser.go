@@ -23,6 +23,7 @@ func HasMarker(s string) bool {
 // Guarantees:
 // - Only one separator (=) in word
 // - All quotes are balanced
+// - A backslash makes the next character literal, ex: \" \' \= \\
 func ScanQuotedWords(data []byte, atEOF bool) (int, []byte, error) {
 	// Skip leading spaces.
 	start := 0
@@ -37,6 +38,7 @@ func ScanQuotedWords(data []byte, atEOF bool) (int, []byte, error) {
 	token := []byte{}
 
 	seenSeparator := false
+	escaped := false
 	quotes := []rune{}
 	for width, i := 0, start; i < len(data); i += width {
 		var r rune
@@ -46,6 +48,17 @@ func ScanQuotedWords(data []byte, atEOF bool) (int, []byte, error) {
 			return 0, nil, errors.New("contains split marker")
 		}
 
+		if escaped {
+			escaped = false
+			token = append(token, string(r)...)
+			continue
+		}
+
+		if isBackslash(r) {
+			escaped = true
+			continue
+		}
+
 		if isQuote(r) {
 			// closing quotes
 			if len(quotes) > 0 {
@@ -66,7 +79,7 @@ func ScanQuotedWords(data []byte, atEOF bool) (int, []byte, error) {
 
 		if insideQuotes {
 			// we are inside quotation marks
-			token = append(token, byte(r))
+			token = append(token, string(r)...)
 			continue
 		}
 
@@ -84,7 +97,7 @@ func ScanQuotedWords(data []byte, atEOF bool) (int, []byte, error) {
 			return i + width, token, nil
 		}
 
-		token = append(token, byte(r))
+		token = append(token, string(r)...)
 	}
 
 	// If we're at EOF, we have a final, non-empty, non-terminated word. Return it.
@@ -93,6 +106,10 @@ func ScanQuotedWords(data []byte, atEOF bool) (int, []byte, error) {
 		if len(quotes) > 0 {
 			return 0, nil, errors.New("double separator")
 		}
+
+		if escaped {
+			return 0, nil, errors.New("dangling escape")
+		}
 		return len(data), token, nil
 	}
 
@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scanWords(s string) ([]string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	scanner.Split(ScanQuotedWords)
+
+	words := []string{}
+	for scanner.Scan() {
+		words = append(words, strings.Replace(scanner.Text(), string(marker), "=", -1))
+	}
+
+	return words, scanner.Err()
+}
+
+func TestScanQuotedWords(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{`a b`, []string{"a", "b"}},
+		{`msg="hello world"`, []string{"msg=hello world"}},
+		{`msg="say \"hi\""`, []string{`msg=say "hi"`}},
+		{`msg='it\'s'`, []string{`msg=it's`}},
+		{`expr=a\=b`, []string{"expr=a=b"}},
+		{`path=c:\\temp`, []string{`path=c:\temp`}},
+		{`\"quoted\"`, []string{`"quoted"`}},
+	}
+
+	for _, tc := range cases {
+		words, err := scanWords(tc.input)
+		assert.Nil(t, err, tc.input)
+		assert.Equal(t, tc.expected, words, tc.input)
+	}
+}
+
+func TestScanQuotedWordsErrors(t *testing.T) {
+	for _, input := range []string{
+		`msg="unbalanced`,
+		`a==b`,
+		`dangling\`,
+	} {
+		_, err := scanWords(input)
+		assert.NotNil(t, err, input)
+	}
+}
+
+func TestScanQuotedWordsEscapedSeparator(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader(`expr=a\=b`))
+	scanner.Split(ScanQuotedWords)
+
+	assert.True(t, scanner.Scan())
+	token := scanner.Text()
+
+	// only the unescaped separator becomes a marker
+	assert.True(t, HasMarker(token))
+	name, value := SplitMarker(token)
+	assert.Equal(t, "expr", name)
+	assert.Equal(t, "a=b", value)
+}
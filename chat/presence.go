@@ -0,0 +1,44 @@
+package chat
+
+import "sort"
+
+// SubscribePresence asks slack for presence changes of userIDs, delivered as EventPresence.
+// Subscriptions add up and are sent again whenever we reconnect.
+func (cb *ChatBot) SubscribePresence(userIDs []string) {
+	cb.mtx.Lock()
+	if cb.presenceIDs == nil {
+		cb.presenceIDs = map[string]bool{}
+	}
+
+	for _, id := range userIDs {
+		cb.presenceIDs[id] = true
+	}
+	cb.mtx.Unlock()
+
+	if cb.Connected() {
+		cb.sendPresenceSub()
+	}
+}
+
+// sendPresenceSub sends every subscription, presence_sub replaces what slack had
+func (cb *ChatBot) sendPresenceSub() {
+	ids := cb.presenceSubscriptions()
+	if len(ids) == 0 || cb.slackRTM == nil {
+		return
+	}
+
+	cb.slackRTM.SendMessage(cb.slackRTM.NewSubscribeUserPresence(ids))
+}
+
+func (cb *ChatBot) presenceSubscriptions() []string {
+	cb.mtx.Lock()
+	defer cb.mtx.Unlock()
+
+	ids := []string{}
+	for id := range cb.presenceIDs {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
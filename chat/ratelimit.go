@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// slack allows roughly one message per second per channel, with short bursts
+	defaultSendRate  = 1.0
+	defaultSendBurst = 5
+)
+
+// tokenBucket refills rate tokens per second, holding at most burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mtx    sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// reserve takes a token, returning how long to wait before using it
+func (tb *tokenBucket) reserve(now time.Time) time.Duration {
+	tb.mtx.Lock()
+	defer tb.mtx.Unlock()
+
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * tb.rate
+		tb.last = now
+	}
+
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// rateLimiter keeps one bucket per channel
+type rateLimiter struct {
+	rate    float64
+	burst   int
+	buckets map[string]*tokenBucket
+	mtx     sync.Mutex
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// delay is how long a message to channelID has to wait, 0 when disabled
+func (rl *rateLimiter) delay(channelID string, now time.Time) time.Duration {
+	if rl == nil || rl.rate <= 0 {
+		return 0
+	}
+
+	rl.mtx.Lock()
+	bucket, ok := rl.buckets[channelID]
+	if !ok {
+		bucket = newTokenBucket(rl.rate, rl.burst, now)
+		rl.buckets[channelID] = bucket
+	}
+	rl.mtx.Unlock()
+
+	return bucket.reserve(now)
+}
+
+// wait blocks until a message to channelID can go out, or ctx is done
+func (rl *rateLimiter) wait(ctx context.Context, channelID string) error {
+	d := rl.delay(channelID, time.Now())
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
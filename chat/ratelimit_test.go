@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	tb := newTokenBucket(1, 2, now)
+
+	// burst goes out right away
+	assert.Equal(t, time.Duration(0), tb.reserve(now))
+	assert.Equal(t, time.Duration(0), tb.reserve(now))
+
+	// then one per second, queued behind each other
+	assert.Equal(t, time.Second, tb.reserve(now))
+	assert.Equal(t, 2*time.Second, tb.reserve(now))
+
+	// refills, but never past the burst
+	later := now.Add(time.Minute)
+	assert.Equal(t, time.Duration(0), tb.reserve(later))
+	assert.Equal(t, time.Duration(0), tb.reserve(later))
+	assert.Equal(t, time.Second, tb.reserve(later))
+}
+
+func TestRateLimiterPerChannel(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(1, 1)
+
+	assert.Equal(t, time.Duration(0), rl.delay("C1", now))
+	assert.Equal(t, time.Second, rl.delay("C1", now))
+	assert.Equal(t, time.Duration(0), rl.delay("C2", now))
+
+	disabled := newRateLimiter(0, 0)
+	assert.Equal(t, time.Duration(0), disabled.delay("C1", now))
+	assert.Equal(t, time.Duration(0), disabled.delay("C1", now))
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	rl := newRateLimiter(0.001, 1)
+	assert.Nil(t, rl.wait(context.Background(), "C123"))
+
+	// next token is ~17 minutes away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, rl.wait(ctx, "C123"))
+}
@@ -0,0 +1,60 @@
+package chat
+
+import (
+	"errors"
+
+	"github.com/nlopes/slack"
+)
+
+var ErrReplyNotAcked = errors.New("reply was never acked by slack")
+
+func (cr *ChatReply) Update(s string, args ...interface{}) error {
+	if cr.Timestamp == "" {
+		return ErrReplyNotAcked
+	}
+
+	text, err := cr.Bot.UpdateMessage(cr.Target, cr.Timestamp, s, args...)
+	if err != nil {
+		return err
+	}
+
+	cr.Text = text
+	return nil
+}
+
+func (cr *ChatReply) Delete() error {
+	if cr.Timestamp == "" {
+		return ErrReplyNotAcked
+	}
+
+	return cr.Bot.DeleteMessage(cr.Target, cr.Timestamp)
+}
+
+// Permalink links to the reply, ex: for cross-posting
+func (cr *ChatReply) Permalink() (string, error) {
+	if cr.Timestamp == "" {
+		return "", ErrReplyNotAcked
+	}
+
+	return cr.Bot.slackAPI.GetPermalink(&slack.PermalinkParameters{
+		Channel: cr.Target.ID(),
+		Ts:      cr.Timestamp,
+	})
+}
+
+// AddReaction decorates the reply, ex: to mark the outcome of async work
+func (cr *ChatReply) AddReaction(reaction string) error {
+	if cr.Timestamp == "" {
+		return ErrReplyNotAcked
+	}
+
+	return cr.Bot.slackAPI.AddReaction(reaction, slack.NewRefToMessage(cr.Target.ID(), cr.Timestamp))
+}
+
+func (cr *ChatReply) RemoveReaction(reaction string) error {
+	if cr.Timestamp == "" {
+		return ErrReplyNotAcked
+	}
+
+	return cr.Bot.slackAPI.RemoveReaction(reaction, slack.NewRefToMessage(cr.Target.ID(), cr.Timestamp))
+}
@@ -0,0 +1,184 @@
+package chat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	scheduleNamespace = "_scheduled_messages"
+
+	// delivery is retried this often while slack is unreachable
+	scheduleRetry = time.Minute
+)
+
+// scheduledMessage is kept in the store until delivered, so restarts don't lose it
+type scheduledMessage struct {
+	ID              string    `json:"id"`
+	ChannelID       string    `json:"channel_id"`
+	ChannelName     string    `json:"channel_name"`
+	ThreadTimestamp string    `json:"thread_timestamp"`
+	Text            string    `json:"text"`
+	When            time.Time `json:"when"`
+}
+
+func (sm *scheduledMessage) StoreID() string {
+	return sm.ID
+}
+
+func (sm *scheduledMessage) StoreExpires() time.Time {
+	return time.Time{}
+}
+
+type scheduler struct {
+	timers  map[string]*time.Timer
+	stopped bool // set by stopScheduled until the next restoreScheduled
+	mtx     sync.Mutex
+
+	send func(sm *scheduledMessage) error // nil for cb.Send, replaced in tests
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		timers: map[string]*time.Timer{},
+	}
+}
+
+// SendAt posts the message to target at when, returning an id for CancelScheduled.
+// Pending messages live in the bot's Store and are resumed once we reconnect.
+func (cb *ChatBot) SendAt(target ChatTarget, when time.Time, s string, args ...interface{}) (string, error) {
+	sm := &scheduledMessage{
+		ID:          uuid.New(),
+		ChannelID:   target.ID(),
+		ChannelName: target.Name(),
+		Text:        fmt.Sprintf(s, args...),
+		When:        when,
+	}
+
+	if err := cb.store.Namespace(scheduleNamespace).Save(sm); err != nil {
+		return "", err
+	}
+
+	cb.arm(sm, time.Until(when), true)
+	return sm.ID, nil
+}
+
+func (cb *ChatBot) SendAfter(target ChatTarget, d time.Duration, s string, args ...interface{}) (string, error) {
+	return cb.SendAt(target, time.Now().Add(d), s, args...)
+}
+
+func (cb *ChatBot) CancelScheduled(id string) error {
+	cb.schedule.mtx.Lock()
+	if timer, ok := cb.schedule.timers[id]; ok {
+		timer.Stop()
+		delete(cb.schedule.timers, id)
+	}
+	cb.schedule.mtx.Unlock()
+
+	return cb.store.Namespace(scheduleNamespace).Delete(id)
+}
+
+// arm delivers sm after d, replace decides what happens when sm is already armed
+func (cb *ChatBot) arm(sm *scheduledMessage, d time.Duration, replace bool) {
+	cb.schedule.mtx.Lock()
+	defer cb.schedule.mtx.Unlock()
+
+	if timer, ok := cb.schedule.timers[sm.ID]; ok {
+		if !replace {
+			return
+		}
+		timer.Stop()
+	}
+
+	cb.schedule.timers[sm.ID] = time.AfterFunc(d, func() { cb.deliver(sm) })
+}
+
+// rearm retries sm after d, unless it was cancelled or the scheduler stopped in the meantime
+func (cb *ChatBot) rearm(sm *scheduledMessage, d time.Duration) bool {
+	cb.schedule.mtx.Lock()
+	defer cb.schedule.mtx.Unlock()
+
+	timer, ok := cb.schedule.timers[sm.ID]
+	if !ok || cb.schedule.stopped {
+		return false
+	}
+
+	timer.Stop()
+	cb.schedule.timers[sm.ID] = time.AfterFunc(d, func() { cb.deliver(sm) })
+	return true
+}
+
+func (cb *ChatBot) sendScheduled(sm *scheduledMessage) error {
+	if cb.schedule.send != nil {
+		return cb.schedule.send(sm)
+	}
+
+	target := &ChatChannel{
+		id:   sm.ChannelID,
+		name: sm.ChannelName,
+	}
+
+	_, err := cb.Send(target, sm.ThreadTimestamp, "%s", sm.Text)
+	return err
+}
+
+func (cb *ChatBot) deliver(sm *scheduledMessage) {
+	ll := cb.Logger().WithField("scheduled_id", sm.ID).WithField("target_id", sm.ChannelID)
+
+	if err := cb.sendScheduled(sm); err != nil {
+		if cb.rearm(sm, scheduleRetry) {
+			ll.WithError(err).Error("could not deliver scheduled message, will retry")
+		} else {
+			ll.WithError(err).Error("could not deliver scheduled message")
+		}
+		return
+	}
+
+	cb.schedule.mtx.Lock()
+	delete(cb.schedule.timers, sm.ID)
+	cb.schedule.mtx.Unlock()
+
+	if err := cb.store.Namespace(scheduleNamespace).Delete(sm.ID); err != nil {
+		ll.WithError(err).Error("could not forget delivered message")
+	}
+}
+
+// restoreScheduled arms messages left in the store, overdue ones go out right away
+func (cb *ChatBot) restoreScheduled() {
+	cb.schedule.mtx.Lock()
+	cb.schedule.stopped = false
+	cb.schedule.mtx.Unlock()
+
+	namespace := cb.store.Namespace(scheduleNamespace)
+
+	ids, err := namespace.Keys()
+	if err != nil {
+		cb.Logger().WithError(err).Error("could not list scheduled messages")
+		return
+	}
+
+	for _, id := range ids {
+		sm := &scheduledMessage{}
+		if err := namespace.FindByID(id, sm); err != nil {
+			cb.Logger().WithField("scheduled_id", id).WithError(err).Error("could not load scheduled message")
+			continue
+		}
+
+		cb.arm(sm, time.Until(sm.When), false)
+	}
+}
+
+// stopScheduled disarms timers, messages stay in the store for the next run
+func (cb *ChatBot) stopScheduled() {
+	cb.schedule.mtx.Lock()
+	defer cb.schedule.mtx.Unlock()
+
+	cb.schedule.stopped = true
+	for id, timer := range cb.schedule.timers {
+		timer.Stop()
+		delete(cb.schedule.timers, id)
+	}
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func armed(cb *ChatBot, id string) bool {
+	cb.schedule.mtx.Lock()
+	defer cb.schedule.mtx.Unlock()
+
+	_, ok := cb.schedule.timers[id]
+	return ok
+}
+
+func TestSendAtPersists(t *testing.T) {
+	cb := testBot(t)
+	defer cb.stopScheduled()
+
+	id, err := cb.SendAfter(&ChatChannel{id: "C123", name: "general"}, time.Hour, "remember %s", "the milk")
+	assert.Nil(t, err)
+	assert.True(t, armed(cb, id))
+
+	sm := &scheduledMessage{}
+	assert.Nil(t, cb.Store().Namespace(scheduleNamespace).FindByID(id, sm))
+	assert.Equal(t, "remember the milk", sm.Text)
+	assert.Equal(t, "C123", sm.ChannelID)
+
+	assert.Nil(t, cb.CancelScheduled(id))
+	assert.False(t, armed(cb, id))
+
+	exists, err := cb.Store().Namespace(scheduleNamespace).Exists(id)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestRestoreScheduled(t *testing.T) {
+	cb := testBot(t)
+	defer cb.stopScheduled()
+
+	// left behind by a previous run
+	sm := &scheduledMessage{
+		ID:        "pending",
+		ChannelID: "C123",
+		Text:      "hello",
+		When:      time.Now().Add(time.Hour),
+	}
+	assert.Nil(t, cb.Store().Namespace(scheduleNamespace).Save(sm))
+
+	cb.restoreScheduled()
+	assert.True(t, armed(cb, "pending"))
+
+	cb.stopScheduled()
+	assert.False(t, armed(cb, "pending"))
+}
+
+func TestScheduledRetriesWhileDisconnected(t *testing.T) {
+	cb := testBot(t)
+	defer cb.stopScheduled()
+
+	sm := &scheduledMessage{
+		ID:        "overdue",
+		ChannelID: "C123",
+		Text:      "hello",
+	}
+	assert.Nil(t, cb.Store().Namespace(scheduleNamespace).Save(sm))
+	cb.arm(sm, time.Hour, true)
+
+	cb.deliver(sm)
+
+	// not connected, stays around for another try
+	assert.True(t, armed(cb, "overdue"))
+	exists, err := cb.Store().Namespace(scheduleNamespace).Exists("overdue")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
+
+func TestScheduledCancelledDuringSend(t *testing.T) {
+	cb := testBot(t)
+	defer cb.stopScheduled()
+
+	id, err := cb.SendAfter(&ChatChannel{id: "C123"}, time.Hour, "hello")
+	assert.Nil(t, err)
+
+	sm := &scheduledMessage{}
+	assert.Nil(t, cb.Store().Namespace(scheduleNamespace).FindByID(id, sm))
+
+	cb.schedule.send = func(sm *scheduledMessage) error {
+		assert.Nil(t, cb.CancelScheduled(sm.ID))
+		return ErrNotConnected
+	}
+
+	cb.deliver(sm)
+	assert.False(t, armed(cb, id))
+}
+
+func TestScheduledStoppedDuringRetry(t *testing.T) {
+	cb := testBot(t)
+	defer cb.stopScheduled()
+
+	sm := &scheduledMessage{
+		ID:        "retrying",
+		ChannelID: "C123",
+		Text:      "hello",
+	}
+	assert.Nil(t, cb.Store().Namespace(scheduleNamespace).Save(sm))
+	cb.arm(sm, time.Hour, true)
+
+	cb.schedule.send = func(sm *scheduledMessage) error {
+		cb.stopScheduled()
+		return ErrNotConnected
+	}
+
+	cb.deliver(sm)
+	assert.False(t, armed(cb, "retrying"))
+
+	// kept for the next run
+	exists, err := cb.Store().Namespace(scheduleNamespace).Exists("retrying")
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
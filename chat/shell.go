@@ -1,16 +1,65 @@
 package chat
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/shlex"
 )
 
+const (
+	// used by NewShellHandler
+	shellDefaultTimeout = 30 * time.Second
+
+	// keeps error replies readable, the tail is what matters
+	shellMaxStderr = 2000
+
+	// slack rejects messages over ~40k chars, stay well below
+	shellDefaultOutputLimit = 4000
+)
+
+var errShellTimeout = errors.New("command timed out")
+
+// shellError carries what a failed command printed to stderr
+type shellError struct {
+	err    error
+	stderr string
+}
+
+func (se *shellError) Error() string {
+	return fmt.Sprintf("%s: %s", se.err, se.stderr)
+}
+
 type shellHandler struct {
-	name    string
-	command string
+	name         string
+	command      string
+	timeout      time.Duration
+	allowedUsers map[string]bool
+	outputLimit  int
+	uploadOutput bool
+}
+
+type shellOpt func(*shellHandler)
+
+// WithAllowedUsers restricts the command to the given slack user ids, no ids denies everyone.
+// For users linked to an external site, register the handler WithAuth instead.
+func WithAllowedUsers(ids ...string) shellOpt {
+	return func(sh *shellHandler) {
+		// non-nil even without ids, see allowed
+		if sh.allowedUsers == nil {
+			sh.allowedUsers = map[string]bool{}
+		}
+
+		for _, id := range ids {
+			sh.allowedUsers[id] = true
+		}
+	}
 }
 
 var _ ChatMessageHandler = &shellHandler{}
@@ -19,44 +68,164 @@ func (sh *shellHandler) Name() string {
 	return sh.name
 }
 
-func NewShellHandler(name string, command string) *shellHandler {
-	return &shellHandler{
-		name:    name,
-		command: command,
+func NewShellHandler(name string, command string, opts ...shellOpt) *shellHandler {
+	return NewShellHandlerWithTimeout(name, command, shellDefaultTimeout, opts...)
+}
+
+// NewShellHandlerWithTimeout kills the command once d elapses
+func NewShellHandlerWithTimeout(name string, command string, d time.Duration, opts ...shellOpt) *shellHandler {
+	sh := &shellHandler{
+		name:        name,
+		command:     command,
+		timeout:     d,
+		outputLimit: shellDefaultOutputLimit,
 	}
+
+	for _, opt := range opts {
+		opt(sh)
+	}
+
+	return sh
+}
+
+// WithOutputLimit truncates replies to limit bytes of output, 0 or less keeps the default
+func WithOutputLimit(limit int) shellOpt {
+	return func(sh *shellHandler) {
+		if limit <= 0 {
+			limit = shellDefaultOutputLimit
+		}
+		sh.outputLimit = limit
+	}
+}
+
+// WithOutputUpload attaches the full output as a snippet when it gets truncated
+func WithOutputUpload() shellOpt {
+	return func(sh *shellHandler) {
+		sh.uploadOutput = true
+	}
+}
+
+// allowed is true for everyone unless WithAllowedUsers was given
+func (sh *shellHandler) allowed(user *ChatUser) bool {
+	if sh.allowedUsers == nil {
+		return true
+	}
+
+	return sh.allowedUsers[user.ID()]
 }
 
 func (sh *shellHandler) OnChatMessage(msg *ChatMessage) error {
+	if !sh.allowed(msg.User) {
+		msg.Logger.WithField("command", sh.name).Warning("user not allowed to run command")
+		msg.AddReaction("no_entry")
+		msg.ReplyPrivately("you are not allowed to run `%s`", sh.name)
+		return nil
+	}
+
+	// runs in the handler's own goroutine, so WithWorkers bounds commands & Stop waits for them
 	msg.AddReaction("timer_clock")
-	go func() {
-		defer msg.RemoveReaction("timer_clock")
-		parsedCmd, err := shlex.Split(sh.command)
-		if err != nil {
-			msg.AddReaction("cry")
-			msg.ReplyPrivately("error parsing command: `%s`", err)
-		}
+	defer msg.RemoveReaction("timer_clock")
 
-		cmd := exec.Command(parsedCmd[0], parsedCmd[1:]...)
-		for _, key := range msg.Args.Keys() {
-			envKey := fmt.Sprintf("JARBAS_ARG_%s", envify(key))
-			envVal, _ := msg.StringArg(key)
-			msg.Logger.WithField("key", envKey).WithField("val", envVal).Info("env var")
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", envKey, envVal))
-		}
+	done := make(chan struct{})
+	defer close(done)
+	go msg.Bot.KeepTyping(msg.Channel, done)
 
-		out, err := cmd.Output()
-		if err != nil {
-			msg.AddReaction("cry")
-			msg.ReplyPrivately("error running command: `%s`", err)
-			return
-		}
+	out, err := sh.run(msg)
+	if err == errShellTimeout {
+		msg.AddReaction("hourglass")
+		msg.ReplyPrivately("command killed after %s", sh.timeout)
+		return nil
+	}
 
-		msg.AddReaction("joy")
-		msg.ReplyInThread("%s", fmt.Sprintf("```\n%s\n```", string(out)))
-	}()
+	if se, ok := err.(*shellError); ok {
+		msg.AddReaction("cry")
+		msg.ReplyPrivately("error running command: `%s`\n```\n%s\n```", se.err, se.stderr)
+		return nil
+	}
+
+	if err != nil {
+		msg.AddReaction("cry")
+		msg.ReplyPrivately("error running command: `%s`", err)
+		return nil
+	}
+
+	msg.AddReaction("joy")
+
+	output, truncated := truncateTail(string(out), sh.outputLimit)
+	msg.ReplyInThread("%s", fmt.Sprintf("```\n%s\n```", output))
+
+	if truncated && sh.uploadOutput {
+		if err := msg.Bot.SendSnippet(msg.Channel, sh.name+".txt", sh.name, "text", string(out)); err != nil {
+			msg.Logger.WithError(err).Error("could not upload command output")
+		}
+	}
 	return nil
 }
 
+// run executes the command, arguments are passed as JARBAS_ARG_* env vars
+func (sh *shellHandler) run(msg *ChatMessage) ([]byte, error) {
+	parsedCmd, err := shlex.Split(sh.command)
+	if err != nil {
+		return nil, fmt.Errorf("parsing command: %s", err)
+	}
+
+	if len(parsedCmd) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sh.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, parsedCmd[0], parsedCmd[1:]...)
+	for _, key := range msg.Args.Keys() {
+		envKey := fmt.Sprintf("JARBAS_ARG_%s", envify(key))
+		envVal, _ := msg.StringArg(key)
+		msg.Logger.WithField("key", envKey).WithField("val", envVal).Info("env var")
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", envKey, envVal))
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, errShellTimeout
+	}
+
+	if err != nil && stderr.Len() > 0 {
+		return nil, &shellError{err: err, stderr: truncateHead(stderr.String(), shellMaxStderr)}
+	}
+
+	return out, err
+}
+
+// truncateTail keeps the first max bytes of s, on a rune boundary
+func truncateTail(s string, max int) (string, bool) {
+	if len(s) <= max {
+		return s, false
+	}
+
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+
+	return s[:max] + "\n…(truncated)", true
+}
+
+// truncateHead keeps at most the last max bytes of s, never splitting a rune
+func truncateHead(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	start := len(s) - max
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+
+	return "..." + s[start:]
+}
+
 func envify(k string) string {
 	return strings.Replace(strings.ToUpper(k), "-", "_", -1)
 }
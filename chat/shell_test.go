@@ -0,0 +1,122 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lxfontes/jarbas/logger"
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func testShellMessage() *ChatMessage {
+	return &ChatMessage{
+		Args:   ChatArgs{"some-arg": "hello"},
+		Logger: logger.NopLogger(),
+	}
+}
+
+func TestShellArgsAsEnv(t *testing.T) {
+	sh := NewShellHandler("echo", `sh -c 'echo $JARBAS_ARG_SOME_ARG'`)
+
+	out, err := sh.run(testShellMessage())
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\n", string(out))
+}
+
+func TestShellEmptyCommand(t *testing.T) {
+	sh := NewShellHandler("empty", "  ")
+
+	_, err := sh.run(testShellMessage())
+	assert.EqualError(t, err, "empty command")
+}
+
+func TestShellTimeout(t *testing.T) {
+	sh := NewShellHandlerWithTimeout("sleep", "sleep 5", 50*time.Millisecond)
+
+	start := time.Now()
+	_, err := sh.run(testShellMessage())
+	assert.Equal(t, errShellTimeout, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestShellStderr(t *testing.T) {
+	sh := NewShellHandler("fail", `sh -c 'echo broken >&2; exit 3'`)
+
+	_, err := sh.run(testShellMessage())
+	if se, ok := err.(*shellError); assert.True(t, ok) {
+		assert.Equal(t, "broken\n", se.stderr)
+	}
+
+	long := strings.Repeat("a", shellMaxStderr) + "end"
+	assert.Equal(t, "..."+long[3:], truncateHead(long, shellMaxStderr))
+
+	// never split a rune
+	assert.Equal(t, "...é", truncateHead("ééé", 3))
+}
+
+func TestShellAllowedUsers(t *testing.T) {
+	alice := &ChatUser{id: "U123"}
+	bob := &ChatUser{id: "U456"}
+
+	open := NewShellHandler("echo", "echo hi")
+	assert.True(t, open.allowed(alice))
+	assert.True(t, open.allowed(bob))
+
+	restricted := NewShellHandler("echo", "echo hi", WithAllowedUsers("U123"))
+	assert.True(t, restricted.allowed(alice))
+	assert.False(t, restricted.allowed(bob))
+
+	// an empty list fails closed
+	nobody := NewShellHandler("echo", "echo hi", WithAllowedUsers())
+	assert.False(t, nobody.allowed(alice))
+	assert.False(t, nobody.allowed(bob))
+
+	ids := []string{}
+	alsoNobody := NewShellHandler("echo", "echo hi", WithAllowedUsers(ids...))
+	assert.False(t, alsoNobody.allowed(alice))
+}
+
+func TestShellOutputLimit(t *testing.T) {
+	out, truncated := truncateTail("short", 10)
+	assert.False(t, truncated)
+	assert.Equal(t, "short", out)
+
+	out, truncated = truncateTail("0123456789abc", 10)
+	assert.True(t, truncated)
+	assert.Equal(t, "0123456789\n…(truncated)", out)
+
+	// never split a rune
+	out, _ = truncateTail("ééé", 3)
+	assert.Equal(t, "é\n…(truncated)", out)
+
+	sh := NewShellHandler("echo", "echo hi", WithOutputLimit(10), WithOutputUpload())
+	assert.Equal(t, 10, sh.outputLimit)
+	assert.True(t, sh.uploadOutput)
+
+	for _, limit := range []int{0, -1} {
+		sh = NewShellHandler("echo", "echo hi", WithOutputLimit(limit))
+		assert.Equal(t, shellDefaultOutputLimit, sh.outputLimit)
+	}
+}
+
+func TestShellRunsInWorkerPool(t *testing.T) {
+	cb := testBot(t)
+	WithWorkers(1)(cb)
+	cb.slackAPI = slack.New("", slack.OptionHTTPClient(slackStub{
+		"reactions.add":    `{"ok": true}`,
+		"reactions.remove": `{"ok": true}`,
+	}))
+	cb.AddMessageHandler("slow", NewShellHandler("slow", "sleep 0.1"))
+
+	// a single worker runs one command at a time, and Stop's wait covers them
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		ev := testMessageEvent("slow")
+		cb.spawnHandler(func() { cb.handleMessage(ev) })
+	}
+	cb.handlers.Wait()
+
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+}
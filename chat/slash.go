@@ -0,0 +1,201 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/lxfontes/jarbas/logger"
+	"github.com/nlopes/slack"
+)
+
+const (
+	responseEphemeral = "ephemeral"
+	responseInChannel = "in_channel"
+)
+
+// ChatSlashCommand is a native slash command, ex: '/deploy api --force'
+type ChatSlashCommand struct {
+	Bot         *ChatBot
+	Command     string // with the leading slash
+	Text        string
+	Args        ChatArgs
+	User        *ChatUser
+	Channel     ChatTarget
+	ResponseURL string
+	TriggerID   string
+	Logger      logger.Log
+}
+
+type ChatSlashHandler interface {
+	ChatHandler
+	OnSlashCommand(cmd *ChatSlashCommand) error
+}
+
+type slashAction struct {
+	handler ChatSlashHandler
+	action  *chatAction // args & description
+}
+
+// Respond is only visible to the user who ran the command
+func (sc *ChatSlashCommand) Respond(s string, args ...interface{}) error {
+	return sc.respond(responseEphemeral, fmt.Sprintf(s, args...))
+}
+
+// RespondInChannel is visible to everyone in the channel, along with the command
+func (sc *ChatSlashCommand) RespondInChannel(s string, args ...interface{}) error {
+	return sc.respond(responseInChannel, fmt.Sprintf(s, args...))
+}
+
+func (sc *ChatSlashCommand) respond(responseType string, text string) error {
+	body, err := json.Marshal(map[string]string{
+		"response_type": responseType,
+		"text":          text,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(sc.ResponseURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response url returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// AddSlashCommandHandler handles command, ex: '/deploy'. Argument options parse the command text
+// the same way as for message handlers, other options are ignored.
+func (cb *ChatBot) AddSlashCommandHandler(command string, handler ChatSlashHandler, opts ...chatOpt) error {
+	command = "/" + strings.TrimPrefix(command, "/")
+
+	ca := &chatAction{}
+	for _, opt := range opts {
+		opt(ca)
+	}
+
+	cb.handlersMtx.Lock()
+	defer cb.handlersMtx.Unlock()
+
+	if _, ok := cb.slashHandlers[command]; ok {
+		return errors.New("slash command already present")
+	}
+
+	cb.slashHandlers[command] = &slashAction{
+		handler: handler,
+		action:  ca,
+	}
+	return nil
+}
+
+// SlashCommandHandler serves the slack app's slash command url, requests are checked against signingSecret.
+// Slack gets an empty answer right away, handlers reply through the command's response url.
+func (cb *ChatBot) SlashCommandHandler(signingSecret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxInteractionBody))
+		if err != nil {
+			http.Error(w, "could not read body", http.StatusBadRequest)
+			return
+		}
+
+		if err := verifySignature(r.Header, body, signingSecret); err != nil {
+			cb.Logger().WithError(err).Warning("rejected slash command")
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		// SlashCommandParse reads the form from the body we just consumed
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		sc, err := slack.SlashCommandParse(r)
+		if err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		cb.handlersMtx.RLock()
+		sa, ok := cb.slashHandlers[sc.Command]
+		cb.handlersMtx.RUnlock()
+		if !ok {
+			cb.Logger().WithField("command", sc.Command).Warning("no handler for slash command")
+			http.NotFound(w, r)
+			return
+		}
+
+		cmd := cb.slashCommandFor(sc)
+		spawned := cb.spawnUnlessDraining(func() {
+			cb.runSlashCommand(sa, cmd)
+		})
+		if !spawned {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func (cb *ChatBot) slashCommandFor(sc slack.SlashCommand) *ChatSlashCommand {
+	cb.directory.addUser(sc.UserID, sc.UserName)
+
+	cmd := &ChatSlashCommand{
+		Bot:     cb,
+		Command: sc.Command,
+		Text:    sc.Text,
+		Args:    ChatArgs{},
+		User:    cb.userFor(sc.UserID, sc.UserName),
+		Channel: &ChatChannel{
+			id:   sc.ChannelID,
+			name: sc.ChannelName,
+		},
+		ResponseURL: sc.ResponseURL,
+		TriggerID:   sc.TriggerID,
+	}
+
+	cmd.Logger = cb.Logger().
+		WithField("command", cmd.Command).
+		WithField("user", cmd.User.ID()).
+		WithField("channel", cmd.Channel.ID())
+
+	return cmd
+}
+
+func (cb *ChatBot) runSlashCommand(sa *slashAction, cmd *ChatSlashCommand) {
+	// parseArguments works on messages, borrow one
+	msg := &ChatMessage{
+		Bot:     cb,
+		Match:   cmd.Command,
+		RawArgs: cmd.Text,
+		Args:    cmd.Args,
+		Logger:  cmd.Logger,
+	}
+
+	if err := parseArguments(sa.action.args, msg); err != nil {
+		cmd.Logger.WithError(err).Info("could not parse arguments")
+		cmd.Respond("%s", err)
+		return
+	}
+
+	cb.observer.HandlerInvoked(sa.handler.Name())
+
+	if err := callSlashHandler(sa.handler, cmd); err != nil {
+		cb.observer.HandlerFailed(sa.handler.Name())
+		withStack(cmd.Logger, err).WithError(err).Error("slash command handler failed")
+		cmd.Respond("Your last command emmited an error\n%+v", err)
+	}
+}
+
+func callSlashHandler(handler ChatSlashHandler, cmd *ChatSlashCommand) (err error) {
+	defer recoverHandler(&err)
+	return handler.OnSlashCommand(cmd)
+}
@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSlashHandler struct {
+	commands []*ChatSlashCommand
+	err      error
+}
+
+func (rsh *recordingSlashHandler) Name() string {
+	return "recording"
+}
+
+func (rsh *recordingSlashHandler) OnSlashCommand(cmd *ChatSlashCommand) error {
+	rsh.commands = append(rsh.commands, cmd)
+	return rsh.err
+}
+
+// testResponseURL records what handlers post to the command's response url
+func testResponseURL(t *testing.T) (*httptest.Server, func() []map[string]string) {
+	var responses []map[string]string
+	var mtx sync.Mutex
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := map[string]string{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&response))
+
+		mtx.Lock()
+		responses = append(responses, response)
+		mtx.Unlock()
+	}))
+
+	return srv, func() []map[string]string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return responses
+	}
+}
+
+func testSlashForm(command string, text string, responseURL string) url.Values {
+	return url.Values{
+		"command":      {command},
+		"text":         {text},
+		"user_id":      {"U123"},
+		"user_name":    {"someone"},
+		"channel_id":   {"C123"},
+		"channel_name": {"general"},
+		"response_url": {responseURL},
+	}
+}
+
+func TestSlashCommand(t *testing.T) {
+	srv, responses := testResponseURL(t)
+	defer srv.Close()
+
+	cb := testBot(t)
+	handler := &recordingSlashHandler{}
+	assert.Nil(t, cb.AddSlashCommandHandler("deploy", handler,
+		WithRequiredArg("app", "what to deploy"),
+		WithFlagArg("force", "skip checks"),
+	))
+	assert.NotNil(t, cb.AddSlashCommandHandler("/deploy", handler))
+
+	rr := httptest.NewRecorder()
+	req := testSignedRequest(testSigningSecret, "/slack/commands", testSlashForm("/deploy", "api --force", srv.URL))
+	cb.SlashCommandHandler(testSigningSecret).ServeHTTP(rr, req)
+	cb.handlers.Wait()
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	if assert.Len(t, handler.commands, 1) {
+		cmd := handler.commands[0]
+		assert.Equal(t, "/deploy", cmd.Command)
+		assert.Equal(t, "api", cmd.Args["app"])
+		assert.Equal(t, "true", cmd.Args["force"])
+		assert.Equal(t, "U123", cmd.User.ID())
+		assert.Equal(t, "someone", cmd.User.Name())
+		assert.Equal(t, "general", cmd.Channel.Name())
+
+		assert.Nil(t, cmd.RespondInChannel("deploying %s", "api"))
+	}
+
+	assert.Equal(t, []map[string]string{
+		{"response_type": "in_channel", "text": "deploying api"},
+	}, responses())
+}
+
+func TestSlashCommandErrors(t *testing.T) {
+	srv, responses := testResponseURL(t)
+	defer srv.Close()
+
+	cb := testBot(t)
+	handler := &recordingSlashHandler{err: errors.New("boom")}
+	cb.AddSlashCommandHandler("/deploy", handler, WithRequiredArg("app", "what to deploy"))
+
+	serve := func(secret string, form url.Values) int {
+		rr := httptest.NewRecorder()
+		cb.SlashCommandHandler(testSigningSecret).ServeHTTP(rr, testSignedRequest(secret, "/slack/commands", form))
+		cb.handlers.Wait()
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("wrong", testSlashForm("/deploy", "api", srv.URL)))
+	assert.Equal(t, http.StatusNotFound, serve(testSigningSecret, testSlashForm("/rollback", "api", srv.URL)))
+	assert.Len(t, responses(), 0)
+
+	// missing argument, handler is not called
+	assert.Equal(t, http.StatusOK, serve(testSigningSecret, testSlashForm("/deploy", "", srv.URL)))
+	assert.Len(t, handler.commands, 0)
+
+	// handler error is reported back
+	assert.Equal(t, http.StatusOK, serve(testSigningSecret, testSlashForm("/deploy", "api", srv.URL)))
+	assert.Len(t, handler.commands, 1)
+
+	if assert.Len(t, responses(), 2) {
+		for _, response := range responses() {
+			assert.Equal(t, "ephemeral", response["response_type"])
+		}
+	}
+}
+
+func TestSlashCommandWhileDraining(t *testing.T) {
+	srv, responses := testResponseURL(t)
+	defer srv.Close()
+
+	cb := testBot(t)
+	handler := &recordingSlashHandler{}
+	cb.AddSlashCommandHandler("deploy", handler)
+
+	cb.mtx.Lock()
+	cb.draining = true
+	cb.mtx.Unlock()
+
+	rr := httptest.NewRecorder()
+	req := testSignedRequest(testSigningSecret, "/slack/commands", testSlashForm("/deploy", "api", srv.URL))
+	cb.SlashCommandHandler(testSigningSecret).ServeHTTP(rr, req)
+	cb.handlers.Wait()
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Len(t, handler.commands, 0)
+	assert.Len(t, responses(), 0)
+}
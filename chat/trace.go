@@ -0,0 +1,16 @@
+package chat
+
+import "context"
+
+type traceIDKey struct{}
+
+// WithTraceID tags ctx with a ChatMessage's TraceID, sends made with ctx log it along with the outgoing message
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID returns the id set by WithTraceID, empty when there is none
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
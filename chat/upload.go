@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/nlopes/slack"
+)
+
+// ErrUploadUnshared is returned along with the reply when slack didn't report a public share for the upload.
+// Our slack client doesn't decode private shares, so uploads to private channels and direct messages
+// can't be edited or deleted later.
+var ErrUploadUnshared = errors.New("upload has no public share timestamp")
+
+type uploadOpt func(*slack.FileUploadParameters)
+
+// WithFileTitle defaults to the file name
+func WithFileTitle(title string) uploadOpt {
+	return func(params *slack.FileUploadParameters) {
+		params.Title = title
+	}
+}
+
+// WithFileType sets the snippet type, ex: 'text', 'go', 'csv'
+func WithFileType(fileType string) uploadOpt {
+	return func(params *slack.FileUploadParameters) {
+		params.Filetype = fileType
+	}
+}
+
+func WithFileComment(comment string) uploadOpt {
+	return func(params *slack.FileUploadParameters) {
+		params.InitialComment = comment
+	}
+}
+
+func WithFileThread(threadTimestamp string) uploadOpt {
+	return func(params *slack.FileUploadParameters) {
+		params.ThreadTimestamp = threadTimestamp
+	}
+}
+
+// UploadFile shares content as a file in target.
+// The reply is returned with ErrUploadUnshared when its Timestamp is unknown, the file was still uploaded.
+func (cb *ChatBot) UploadFile(target ChatTarget, filename string, content []byte, opts ...uploadOpt) (*ChatReply, error) {
+	params := slack.FileUploadParameters{
+		Filename: filename,
+		Title:    filename,
+		Reader:   bytes.NewReader(content),
+		Channels: []string{target.ID()},
+	}
+
+	for _, opt := range opts {
+		opt(&params)
+	}
+
+	file, err := cb.slackAPI.UploadFile(params)
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &ChatReply{
+		Bot:    cb,
+		Text:   params.Title,
+		Target: target,
+	}
+
+	// the share timestamp lets callers delete the upload later
+	shares := file.Shares.Public[target.ID()]
+	if len(shares) == 0 {
+		return cr, ErrUploadUnshared
+	}
+
+	cr.Timestamp = shares[0].Ts
+	return cr, nil
+}
@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+// slackStub answers web api calls with canned json, keyed by method
+type slackStub map[string]string
+
+func (ss slackStub) Do(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		ioutil.ReadAll(r.Body)
+	}
+
+	body, ok := ss[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]]
+	if !ok {
+		body = `{"ok": false, "error": "unknown_method"}`
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+	}, nil
+}
+
+func TestUploadFile(t *testing.T) {
+	cb := testBot(t)
+	cb.slackAPI = slack.New("", slack.OptionHTTPClient(slackStub{
+		"auth.test":    `{"ok": true}`,
+		"files.upload": `{"ok": true, "file": {"id": "F123", "shares": {"public": {"C123": [{"ts": "1234.5678"}]}}}}`,
+	}))
+
+	cr, err := cb.UploadFile(&ChatChannel{id: "C123"}, "report.csv", []byte("a,b"), WithFileTitle("report"))
+	assert.Nil(t, err)
+	if assert.NotNil(t, cr) {
+		assert.Equal(t, "1234.5678", cr.Timestamp)
+		assert.Equal(t, "report", cr.Text)
+	}
+}
+
+func TestUploadFilePrivate(t *testing.T) {
+	cb := testBot(t)
+	cb.slackAPI = slack.New("", slack.OptionHTTPClient(slackStub{
+		"auth.test":    `{"ok": true}`,
+		"files.upload": `{"ok": true, "file": {"id": "F123", "shares": {"private": {"G123": [{"ts": "1234.5678"}]}}}}`,
+	}))
+
+	// uploaded, but there is no telling where
+	cr, err := cb.UploadFile(&ChatChannel{id: "G123"}, "report.csv", []byte("a,b"))
+	assert.Equal(t, ErrUploadUnshared, err)
+	if assert.NotNil(t, cr) {
+		assert.Equal(t, "", cr.Timestamp)
+	}
+}
+
+func TestUploadFileFailure(t *testing.T) {
+	cb := testBot(t)
+	cb.slackAPI = slack.New("", slack.OptionHTTPClient(slackStub{
+		"auth.test": `{"ok": true}`,
+	}))
+
+	cr, err := cb.UploadFile(&ChatChannel{id: "C123"}, "report.csv", []byte("a,b"))
+	assert.NotNil(t, err)
+	assert.Nil(t, cr)
+}
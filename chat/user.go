@@ -42,3 +42,45 @@ func (ct *ChatUser) Bot() *ChatBot {
 func (ct *ChatUser) Logger() logger.Log {
 	return ct.ll
 }
+
+type chatExternalUser struct {
+	user  *ChatUser
+	site  string // filled in by AuthorizeUser
+	name  string
+	id    string
+	token string
+}
+
+var _ ChatExternalUser = &chatExternalUser{}
+
+// NewChatExternalUser is a ready made ChatExternalUser for auth handlers, linking user to
+// an account on the handler's site. AuthorizeUser sets the site.
+func NewChatExternalUser(user *ChatUser, name string, id string, token string) ChatExternalUser {
+	return &chatExternalUser{
+		user:  user,
+		name:  name,
+		id:    id,
+		token: token,
+	}
+}
+
+// User is the slack user owning this account
+func (eu *chatExternalUser) User() *ChatUser {
+	return eu.user
+}
+
+func (eu *chatExternalUser) Site() string {
+	return eu.site
+}
+
+func (eu *chatExternalUser) Name() string {
+	return eu.name
+}
+
+func (eu *chatExternalUser) ID() string {
+	return eu.id
+}
+
+func (eu *chatExternalUser) Token() string {
+	return eu.token
+}
@@ -0,0 +1,75 @@
+package chat
+
+import "sync"
+
+// workerPool runs at most size functions at once, the rest wait in a queue.
+// Queued work doesn't hold a goroutine, and submit never blocks.
+type workerPool struct {
+	size    int
+	running int
+	queue   []func()
+	mtx     sync.Mutex
+}
+
+func newWorkerPool(size int) *workerPool {
+	return &workerPool{
+		size: size,
+	}
+}
+
+func (wp *workerPool) submit(fn func()) {
+	wp.mtx.Lock()
+	defer wp.mtx.Unlock()
+
+	if wp.running >= wp.size {
+		wp.queue = append(wp.queue, fn)
+		return
+	}
+
+	wp.running++
+	go wp.work(fn)
+}
+
+// work keeps taking from the queue until it is empty
+func (wp *workerPool) work(fn func()) {
+	for fn != nil {
+		fn()
+
+		wp.mtx.Lock()
+		fn = nil
+		if len(wp.queue) > 0 {
+			fn = wp.queue[0]
+			wp.queue = wp.queue[1:]
+		} else {
+			wp.running--
+		}
+		wp.mtx.Unlock()
+	}
+}
+
+// WithWorkers runs at most n message handlers at once, messages arriving
+// while all workers are busy are queued. By default every message gets its own goroutine,
+// n of 0 or less keeps that.
+func WithWorkers(n int) botOpt {
+	return func(cb *ChatBot) {
+		if n <= 0 {
+			cb.workers = nil
+			return
+		}
+		cb.workers = newWorkerPool(n)
+	}
+}
+
+// spawnHandler is spawn, going through the worker pool when there is one
+func (cb *ChatBot) spawnHandler(fn func()) {
+	if cb.workers == nil {
+		cb.spawn(fn)
+		return
+	}
+
+	cb.handlers.Add(1)
+	cb.workers.submit(func() {
+		defer cb.handlers.Done()
+		fn()
+	})
+}
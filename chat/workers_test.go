@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPoolBounds(t *testing.T) {
+	wp := newWorkerPool(2)
+
+	var wg sync.WaitGroup
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		wp.submit(func() {
+			defer wg.Done()
+			started <- struct{}{}
+			<-release
+		})
+	}
+
+	<-started
+	<-started
+
+	wp.mtx.Lock()
+	assert.Equal(t, 2, wp.running)
+	assert.Len(t, wp.queue, 8)
+	wp.mtx.Unlock()
+
+	close(release)
+	wg.Wait()
+
+	wp.mtx.Lock()
+	assert.Len(t, wp.queue, 0)
+	wp.mtx.Unlock()
+}
+
+func TestWorkersRunHandlers(t *testing.T) {
+	cb := testBot(t)
+	WithWorkers(1)(cb)
+
+	handler := &recordingHandler{name: "ping"}
+	cb.AddMessageHandler("ping", handler)
+
+	for i := 0; i < 5; i++ {
+		cb.handleEvent(slack.RTMEvent{Data: testMessageEvent("ping")})
+	}
+
+	cb.handlers.Wait()
+	assert.Len(t, handler.messages, 5)
+}
+
+func TestWorkersUnbounded(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		cb := testBot(t)
+		WithWorkers(n)(cb)
+		assert.Nil(t, cb.workers)
+
+		handler := &recordingHandler{name: "ping"}
+		cb.AddMessageHandler("ping", handler)
+		cb.handleEvent(slack.RTMEvent{Data: testMessageEvent("ping")})
+
+		cb.handlers.Wait()
+		assert.Len(t, handler.messages, 1)
+	}
+}
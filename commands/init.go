@@ -2,12 +2,39 @@ package commands
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/lxfontes/jarbas/chat"
+	"github.com/lxfontes/jarbas/store"
 )
 
+// reaction counts are kept per message timestamp, so they survive restarts
+const trackNamespace = "track"
+
+// tracked messages are forgotten after this long, reactions to them stop counting
+var trackTTL = 30 * 24 * time.Hour
+
+// trackCounter seeds a zero counter, Incr keeps its expiration
+type trackCounter struct {
+	timestamp string
+	expires   time.Time
+}
+
+var _ store.Storable = &trackCounter{}
+
+func (tc *trackCounter) StoreID() string {
+	return tc.timestamp
+}
+
+func (tc *trackCounter) StoreExpires() time.Time {
+	return tc.expires
+}
+
+func (tc *trackCounter) MarshalJSON() ([]byte, error) {
+	return []byte("0"), nil
+}
+
 type trackHandler struct {
-	trackMoji map[string]int
 }
 
 var _ chat.ChatMessageHandler = &trackHandler{}
@@ -23,29 +50,60 @@ func (th *trackHandler) OnChatMessage(msg *chat.ChatMessage) error {
 		return err
 	}
 
-	th.trackMoji[cr.Timestamp] = 0
+	if err := th.track(msg.Bot.Store(), cr.Timestamp); err != nil {
+		return err
+	}
 
 	return msg.Bot.AddReaction(msg, "aw_yeah")
 }
 
+// track starts counting reactions to the message at timestamp
+func (th *trackHandler) track(s store.Store, timestamp string) error {
+	return s.Namespace(trackNamespace).Save(&trackCounter{
+		timestamp: timestamp,
+		expires:   time.Now().Add(trackTTL),
+	})
+}
+
+// count applies the reaction, ok is false for messages we don't track
+func (th *trackHandler) count(s store.Store, data *chat.ChatEventReaction) (count int64, ok bool, err error) {
+	namespace := s.Namespace(trackNamespace)
+
+	if ok, err = namespace.Exists(data.Timestamp); err != nil || !ok {
+		return 0, false, err
+	}
+
+	delta := int64(1)
+	if data.Removed {
+		delta = -1
+	}
+
+	if count, err = namespace.Incr(data.Timestamp, delta); err != nil {
+		return 0, false, err
+	}
+
+	// reactions that predate tracking can take the counter below zero
+	if count < 0 {
+		count = 0
+	}
+
+	return count, true, nil
+}
+
 func (th *trackHandler) OnChatEvent(ev *chat.ChatEvent) error {
 	switch data := ev.Data.(type) {
 	case *chat.ChatEventReaction:
-		curVal, ok := th.trackMoji[data.Timestamp]
+		count, ok, err := th.count(ev.Bot.Store(), data)
+		if err != nil {
+			return err
+		}
+
 		if !ok {
 			fmt.Println("not tracking", data.Timestamp)
 			return nil
 		}
 
-		if data.Removed {
-			if curVal > 0 {
-				th.trackMoji[data.Timestamp]--
-			}
-		} else {
-			th.trackMoji[data.Timestamp]++
-		}
-
-		ev.Bot.Send(data.Channel, data.Timestamp, "thx for reaction .... counting %d", th.trackMoji[data.Timestamp])
+		ev.Bot.Send(data.Channel, data.Timestamp, "thx for reaction .... counting %d", count)
 	default:
 		fmt.Println("wut?", ev.Type)
 	}
@@ -53,24 +111,24 @@ func (th *trackHandler) OnChatEvent(ev *chat.ChatEvent) error {
 }
 
 func RegisterHandlers(b *chat.ChatBot) error {
-	th := &trackHandler{
-		trackMoji: map[string]int{},
-	}
-	b.AddMessageHandler("track", th)
+	th := &trackHandler{}
+	b.AddMessageHandler("track", th, chat.WithDescription("counts reactions on a message"))
 
 	b.AddEventHandler(chat.EventReaction, th)
 
 	tt := &testHandler{}
-	b.AddMessageHandler(saveLog, tt)
-	b.AddMessageHandler(showLog, tt)
+	b.AddMessageHandler(saveLog, tt, chat.WithDescription("appends to the room log"))
+	b.AddMessageHandler(showLog, tt, chat.WithDescription("shows the room log"))
 
 	b.AddMessageHandler("ping",
 		chat.NewShellHandler("ping", "ping that"),
+		chat.WithDescription("pings a host"),
 		chat.WithRequiredArg("host", "host to ping"),
 	)
 
 	b.AddMessageHandler("say",
 		chat.NewShellHandler("say", "say something"),
+		chat.WithDescription("speaks out loud"),
 		chat.WithRequiredArg("say-text", "text to say"),
 	)
 
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lxfontes/jarbas/chat"
+	"github.com/lxfontes/jarbas/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackCounts(t *testing.T) {
+	s := store.NewMemoryStore()
+	th := &trackHandler{}
+	reaction := &chat.ChatEventReaction{Timestamp: "1234.5678"}
+
+	// untracked messages leave nothing behind
+	_, ok, err := th.count(s, reaction)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	exists, err := s.Namespace(trackNamespace).Exists("1234.5678")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	assert.Nil(t, th.track(s, "1234.5678"))
+
+	count, ok, err := th.count(s, reaction)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), count)
+
+	removed := &chat.ChatEventReaction{Timestamp: "1234.5678", Removed: true}
+	th.count(s, removed)
+	count, _, err = th.count(s, removed)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestTrackExpires(t *testing.T) {
+	defer func(ttl time.Duration) { trackTTL = ttl }(trackTTL)
+	trackTTL = 20 * time.Millisecond
+
+	s := store.NewMemoryStore()
+	th := &trackHandler{}
+	assert.Nil(t, th.track(s, "1234.5678"))
+
+	// counting keeps the expiration
+	_, ok, err := th.count(s, &chat.ChatEventReaction{Timestamp: "1234.5678"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	time.Sleep(50 * time.Millisecond)
+
+	_, ok, err = th.count(s, &chat.ChatEventReaction{Timestamp: "1234.5678"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,154 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var _ Log = &CaptureLog{}
+
+// Entry is a line recorded by CaptureLog
+type Entry struct {
+	Level   string // debug, info, warning, error or fatal
+	Message string
+	Fields  map[string]interface{}
+}
+
+type captureRecorder struct {
+	mtx     sync.Mutex
+	entries []Entry
+}
+
+// CaptureLog records entries for test assertions instead of writing them out.
+// Loggers derived with WithField & co record into the same list. Fatal doesn't exit.
+type CaptureLog struct {
+	recorder *captureRecorder
+	fields   map[string]interface{}
+	level    uint32 // logrus.Level, atomic
+}
+
+// CaptureLogger records every level, see SetLevel
+func CaptureLogger() *CaptureLog {
+	return &CaptureLog{
+		recorder: &captureRecorder{},
+		fields:   map[string]interface{}{},
+		level:    uint32(logrus.DebugLevel),
+	}
+}
+
+// Entries returns everything recorded so far, by this logger or derived ones
+func (cl *CaptureLog) Entries() []Entry {
+	cl.recorder.mtx.Lock()
+	defer cl.recorder.mtx.Unlock()
+
+	return append([]Entry{}, cl.recorder.entries...)
+}
+
+func (cl *CaptureLog) record(level logrus.Level, message string) {
+	if logrus.Level(atomic.LoadUint32(&cl.level)) < level {
+		return
+	}
+
+	fields := make(map[string]interface{}, len(cl.fields))
+	for k, v := range cl.fields {
+		fields[k] = v
+	}
+
+	cl.recorder.mtx.Lock()
+	defer cl.recorder.mtx.Unlock()
+
+	cl.recorder.entries = append(cl.recorder.entries, Entry{
+		Level:   level.String(),
+		Message: message,
+		Fields:  fields,
+	})
+}
+
+func (cl *CaptureLog) WithField(key string, value interface{}) Log {
+	return cl.WithFields(map[string]interface{}{key: value})
+}
+
+func (cl *CaptureLog) WithFields(fields map[string]interface{}) Log {
+	derived := &CaptureLog{
+		recorder: cl.recorder,
+		fields:   make(map[string]interface{}, len(cl.fields)+len(fields)),
+		level:    atomic.LoadUint32(&cl.level),
+	}
+
+	for k, v := range cl.fields {
+		derived.fields[k] = v
+	}
+
+	for k, v := range fields {
+		derived.fields[k] = v
+	}
+
+	return derived
+}
+
+// WithError records the same fields as the logrus bridge, nil adds none
+func (cl *CaptureLog) WithError(err error) Log {
+	if err == nil {
+		return cl.WithFields(nil)
+	}
+
+	fields := map[string]interface{}{logrus.ErrorKey: err}
+	if cause := rootCause(err); cause != err {
+		fields["cause"] = cause.Error()
+	}
+
+	return cl.WithFields(fields)
+}
+
+func (cl *CaptureLog) SetLevel(level string) error {
+	l, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	atomic.StoreUint32(&cl.level, uint32(l))
+	return nil
+}
+
+func (cl *CaptureLog) Debug(opts ...interface{}) {
+	cl.record(logrus.DebugLevel, fmt.Sprint(opts...))
+}
+
+func (cl *CaptureLog) Debugf(s string, opts ...interface{}) {
+	cl.record(logrus.DebugLevel, fmt.Sprintf(s, opts...))
+}
+
+func (cl *CaptureLog) Error(opts ...interface{}) {
+	cl.record(logrus.ErrorLevel, fmt.Sprint(opts...))
+}
+
+func (cl *CaptureLog) Errorf(s string, opts ...interface{}) {
+	cl.record(logrus.ErrorLevel, fmt.Sprintf(s, opts...))
+}
+
+func (cl *CaptureLog) Fatal(opts ...interface{}) {
+	cl.record(logrus.FatalLevel, fmt.Sprint(opts...))
+}
+
+func (cl *CaptureLog) Fatalf(s string, opts ...interface{}) {
+	cl.record(logrus.FatalLevel, fmt.Sprintf(s, opts...))
+}
+
+func (cl *CaptureLog) Info(opts ...interface{}) {
+	cl.record(logrus.InfoLevel, fmt.Sprint(opts...))
+}
+
+func (cl *CaptureLog) Infof(s string, opts ...interface{}) {
+	cl.record(logrus.InfoLevel, fmt.Sprintf(s, opts...))
+}
+
+func (cl *CaptureLog) Warning(opts ...interface{}) {
+	cl.record(logrus.WarnLevel, fmt.Sprint(opts...))
+}
+
+func (cl *CaptureLog) Warningf(s string, opts ...interface{}) {
+	cl.record(logrus.WarnLevel, fmt.Sprintf(s, opts...))
+}
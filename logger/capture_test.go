@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureLogger(t *testing.T) {
+	cl := CaptureLogger()
+
+	ll := cl.WithField("user", "U123")
+	ll.WithFields(map[string]interface{}{"channel": "C123"}).Infof("hello %s", "there")
+
+	cause := errors.New("timeout")
+	ll.WithError(fmt.Errorf("could not send: %w", cause)).Error("failed")
+	ll.WithError(nil).Warning("nothing wrong")
+
+	assert.Nil(t, cl.SetLevel("error"))
+	cl.Info("dropped")
+	assert.NotNil(t, cl.SetLevel("loud"))
+
+	entries := cl.Entries()
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, Entry{
+			Level:   "info",
+			Message: "hello there",
+			Fields:  map[string]interface{}{"user": "U123", "channel": "C123"},
+		}, entries[0])
+
+		assert.Equal(t, "error", entries[1].Level)
+		assert.Equal(t, "timeout", entries[1].Fields["cause"])
+
+		assert.Equal(t, Entry{
+			Level:   "warning",
+			Message: "nothing wrong",
+			Fields:  map[string]interface{}{"user": "U123"},
+		}, entries[2])
+	}
+}
+
+func TestCaptureWithNilErrorDerives(t *testing.T) {
+	cl := CaptureLogger()
+
+	derived := cl.WithError(nil)
+	assert.Nil(t, derived.SetLevel("error"))
+
+	cl.Info("parent")
+	derived.Info("derived")
+
+	entries := cl.Entries()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "parent", entries[0].Message)
+	}
+}
+
+func TestCaptureSetLevelConcurrent(t *testing.T) {
+	cl := CaptureLogger()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			cl.SetLevel("error")
+		}()
+		go func() {
+			defer wg.Done()
+			cl.WithField("n", 1).Error("hello")
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, cl.Entries(), 10)
+}
@@ -1,14 +1,19 @@
 package logger
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"sync/atomic"
 
 	"github.com/Sirupsen/logrus"
 )
 
 type Log interface {
 	WithField(key string, value interface{}) Log
+	WithFields(fields map[string]interface{}) Log
 	WithError(err error) Log
+	SetLevel(level string) error
 	Debug(...interface{})
 	Debugf(string, ...interface{})
 	Error(...interface{})
@@ -24,29 +29,98 @@ type Log interface {
 var _ Log = &logrusBridge{}
 
 type logrusBridge struct {
-	log *logrus.Entry
+	log   *logrus.Entry
+	level uint32 // logrus.Level per bridge, atomic. See SetLevel.
 }
 
-func (lb *logrusBridge) WithError(err error) Log {
+func (lb *logrusBridge) getLevel() logrus.Level {
+	return logrus.Level(atomic.LoadUint32(&lb.level))
+}
+
+func (lb *logrusBridge) enabled(level logrus.Level) bool {
+	return lb.getLevel() >= level
+}
+
+// derive copies lb's current level into a bridge logging to entry
+func (lb *logrusBridge) derive(entry *logrus.Entry) *logrusBridge {
 	return &logrusBridge{
-		log: lb.log.WithError(err),
+		log:   entry,
+		level: uint32(lb.getLevel()),
+	}
+}
+
+// WithError adds nothing for nil errors. Wrapped errors also get their root cause logged.
+func (lb *logrusBridge) WithError(err error) Log {
+	if err == nil {
+		// still derived, SetLevel on it must not reach lb
+		return lb.derive(lb.log)
+	}
+
+	entry := lb.log.WithError(err)
+	if cause := rootCause(err); cause != err {
+		entry = entry.WithField("cause", cause.Error())
+	}
+
+	return lb.derive(entry)
+}
+
+// rootCause follows errors.Unwrap down to the innermost error
+func rootCause(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
+
+// SetLevel only affects this logger and the ones derived from it afterwards.
+// The underlying logrus logger is made as verbose as needed, other bridges keep filtering on their own level.
+func (lb *logrusBridge) SetLevel(level string) error {
+	l, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	raiseLevel(lb.log.Logger, l)
+	atomic.StoreUint32(&lb.level, uint32(l))
+	return nil
+}
+
+// raiseLevel makes ll at least as verbose as level, never less
+func raiseLevel(ll *logrus.Logger, level logrus.Level) {
+	current := (*uint32)(&ll.Level)
+	for {
+		old := atomic.LoadUint32(current)
+		if logrus.Level(old) >= level || atomic.CompareAndSwapUint32(current, old, uint32(level)) {
+			return
+		}
 	}
 }
 
 func (lb *logrusBridge) Debug(opts ...interface{}) {
-	lb.log.Debug(opts...)
+	if lb.enabled(logrus.DebugLevel) {
+		lb.log.Debug(opts...)
+	}
 }
 
 func (lb *logrusBridge) Debugf(s string, opts ...interface{}) {
-	lb.log.Debugf(s, opts...)
+	if lb.enabled(logrus.DebugLevel) {
+		lb.log.Debugf(s, opts...)
+	}
 }
 
 func (lb *logrusBridge) Error(opts ...interface{}) {
-	lb.log.Error(opts...)
+	if lb.enabled(logrus.ErrorLevel) {
+		lb.log.Error(opts...)
+	}
 }
 
 func (lb *logrusBridge) Errorf(s string, opts ...interface{}) {
-	lb.log.Errorf(s, opts...)
+	if lb.enabled(logrus.ErrorLevel) {
+		lb.log.Errorf(s, opts...)
+	}
 }
 
 func (lb *logrusBridge) Fatal(opts ...interface{}) {
@@ -58,34 +132,94 @@ func (lb *logrusBridge) Fatalf(s string, opts ...interface{}) {
 }
 
 func (lb *logrusBridge) Warning(opts ...interface{}) {
-	lb.log.Warning(opts...)
+	if lb.enabled(logrus.WarnLevel) {
+		lb.log.Warning(opts...)
+	}
 }
 
 func (lb *logrusBridge) Warningf(s string, opts ...interface{}) {
-	lb.log.Warningf(s, opts...)
+	if lb.enabled(logrus.WarnLevel) {
+		lb.log.Warningf(s, opts...)
+	}
 }
 
 func (lb *logrusBridge) Info(opts ...interface{}) {
-	lb.log.Info(opts...)
+	if lb.enabled(logrus.InfoLevel) {
+		lb.log.Info(opts...)
+	}
 }
 
 func (lb *logrusBridge) Infof(s string, opts ...interface{}) {
-	lb.log.Infof(s, opts...)
+	if lb.enabled(logrus.InfoLevel) {
+		lb.log.Infof(s, opts...)
+	}
 }
 
 func (lb *logrusBridge) WithField(key string, value interface{}) Log {
-	return &logrusBridge{
-		log: lb.log.WithField(key, value),
+	return lb.derive(lb.log.WithField(key, value))
+}
+
+func (lb *logrusBridge) WithFields(fields map[string]interface{}) Log {
+	return lb.derive(lb.log.WithFields(logrus.Fields(fields)))
+}
+
+// parseLevel accepts trace, debug, info, warn and error.
+// logrus has no trace level, trace is as verbose as debug.
+func parseLevel(level string) (logrus.Level, error) {
+	switch level {
+	case "trace", "debug":
+		return logrus.DebugLevel, nil
+	case "info":
+		return logrus.InfoLevel, nil
+	case "warn", "warning":
+		return logrus.WarnLevel, nil
+	case "error":
+		return logrus.ErrorLevel, nil
 	}
+
+	return logrus.InfoLevel, fmt.Errorf("unknown log level '%s'", level)
 }
 
+// NewLogger writes text at level, see parseLevel
+func NewLogger(level string) (Log, error) {
+	l, err := parseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLogger(l, false), nil
+}
+
+// DefaultLogger reads LOG_LEVEL (or DEBUG) and LOG_FORMAT=json from the environment
 func DefaultLogger() Log {
-	ll := logrus.New()
+	return newLogger(envLevel(), os.Getenv("LOG_FORMAT") == "json")
+}
+
+// JSONLogger writes one json object per line, for log shippers
+func JSONLogger() Log {
+	return newLogger(envLevel(), true)
+}
+
+func envLevel() logrus.Level {
 	if os.Getenv("DEBUG") != "" {
-		ll.Level = logrus.DebugLevel
+		return logrus.DebugLevel
+	}
+
+	// bad values fall back to info
+	level, _ := parseLevel(os.Getenv("LOG_LEVEL"))
+	return level
+}
+
+func newLogger(level logrus.Level, json bool) Log {
+	ll := logrus.New()
+	ll.Level = level
+
+	if json {
+		ll.Formatter = &logrus.JSONFormatter{}
 	}
 
 	return &logrusBridge{
-		log: ll.WithFields(logrus.Fields{}),
+		log:   ll.WithFields(logrus.Fields{}),
+		level: uint32(level),
 	}
 }
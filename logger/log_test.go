@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// bufferLogger is newLogger writing to a buffer
+func bufferLogger(level logrus.Level, json bool) (*logrusBridge, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	lb := newLogger(level, json).(*logrusBridge)
+	lb.log.Logger.Out = buf
+	return lb, buf
+}
+
+func TestWithNilErrorDerives(t *testing.T) {
+	lb, buf := bufferLogger(logrus.InfoLevel, false)
+
+	derived := lb.WithError(nil)
+	assert.Nil(t, derived.SetLevel("error"))
+
+	lb.Info("parent")
+	derived.Info("derived")
+	assert.Contains(t, buf.String(), "parent")
+	assert.NotContains(t, buf.String(), "derived")
+}
+
+func TestJSONLogger(t *testing.T) {
+	lb, buf := bufferLogger(logrus.InfoLevel, true)
+	lb.WithField("user", "U123").Info("hello")
+
+	line := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "hello", line["msg"])
+	assert.Equal(t, "info", line["level"])
+	assert.Equal(t, "U123", line["user"])
+}
+
+func TestDefaultLoggerFormat(t *testing.T) {
+	defer os.Unsetenv("LOG_FORMAT")
+
+	os.Setenv("LOG_FORMAT", "json")
+	assert.IsType(t, &logrus.JSONFormatter{}, DefaultLogger().(*logrusBridge).log.Logger.Formatter)
+
+	os.Unsetenv("LOG_FORMAT")
+	assert.IsType(t, &logrus.TextFormatter{}, DefaultLogger().(*logrusBridge).log.Logger.Formatter)
+}
+
+func TestNewLogger(t *testing.T) {
+	ll, err := NewLogger("warn")
+	assert.Nil(t, err)
+	assert.Equal(t, logrus.WarnLevel, ll.(*logrusBridge).getLevel())
+
+	_, err = NewLogger("loud")
+	assert.NotNil(t, err)
+}
+
+func TestSetLevel(t *testing.T) {
+	lb, buf := bufferLogger(logrus.InfoLevel, false)
+	verbose := lb.WithField("component", "store")
+
+	// more verbose than the logrus logger underneath
+	assert.Nil(t, verbose.SetLevel("debug"))
+	verbose.Debug("from verbose")
+	lb.Debug("from parent")
+	assert.Contains(t, buf.String(), "from verbose")
+	assert.NotContains(t, buf.String(), "from parent")
+
+	assert.Nil(t, verbose.SetLevel("error"))
+	verbose.Info("quiet now")
+	assert.NotContains(t, buf.String(), "quiet now")
+
+	assert.NotNil(t, verbose.SetLevel("loud"))
+}
+
+func TestSetLevelConcurrent(t *testing.T) {
+	lb, _ := bufferLogger(logrus.InfoLevel, false)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			lb.SetLevel("debug")
+			lb.SetLevel("error")
+		}()
+		go func() {
+			defer wg.Done()
+			lb.WithField("n", 1).Info("hello")
+		}()
+	}
+	wg.Wait()
+}
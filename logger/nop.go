@@ -0,0 +1,38 @@
+package logger
+
+var _ Log = nopLogger{}
+
+type nopLogger struct{}
+
+// NopLogger discards everything, Fatal doesn't exit either
+func NopLogger() Log {
+	return nopLogger{}
+}
+
+func (nl nopLogger) WithField(key string, value interface{}) Log {
+	return nl
+}
+
+func (nl nopLogger) WithFields(fields map[string]interface{}) Log {
+	return nl
+}
+
+func (nl nopLogger) WithError(err error) Log {
+	return nl
+}
+
+func (nl nopLogger) SetLevel(level string) error {
+	_, err := parseLevel(level)
+	return err
+}
+
+func (nl nopLogger) Debug(...interface{})            {}
+func (nl nopLogger) Debugf(string, ...interface{})   {}
+func (nl nopLogger) Error(...interface{})            {}
+func (nl nopLogger) Errorf(string, ...interface{})   {}
+func (nl nopLogger) Fatal(...interface{})            {}
+func (nl nopLogger) Fatalf(string, ...interface{})   {}
+func (nl nopLogger) Info(...interface{})             {}
+func (nl nopLogger) Infof(string, ...interface{})    {}
+func (nl nopLogger) Warning(...interface{})          {}
+func (nl nopLogger) Warningf(string, ...interface{}) {}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/lxfontes/jarbas/chat"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics is a chat.Observer exporting prometheus collectors
+type Metrics struct {
+	MessagesReceived   prometheus.Counter
+	HandlerInvocations *prometheus.CounterVec
+	HandlerErrors      *prometheus.CounterVec
+	Sends              *prometheus.CounterVec
+	StoreLatency       *prometheus.HistogramVec
+}
+
+var _ chat.Observer = &Metrics{}
+
+// New registers jarbas collectors in reg
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		MessagesReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "jarbas_messages_received_total",
+			Help: "Messages received from slack, excluding our own.",
+		}),
+		HandlerInvocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jarbas_handler_invocations_total",
+			Help: "Message handler invocations.",
+		}, []string{"handler"}),
+		HandlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jarbas_handler_errors_total",
+			Help: "Message handlers returning an error.",
+		}, []string{"handler"}),
+		Sends: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jarbas_messages_sent_total",
+			Help: "Messages sent over rtm, by result.",
+		}, []string{"result"}),
+		StoreLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "jarbas_store_duration_seconds",
+			Help:    "Store operation latency.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"op"}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.MessagesReceived,
+		m.HandlerInvocations,
+		m.HandlerErrors,
+		m.Sends,
+		m.StoreLatency,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Handler serves everything in reg, mount it on /metrics
+func Handler(reg prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) MessageReceived() {
+	m.MessagesReceived.Inc()
+}
+
+func (m *Metrics) HandlerInvoked(handler string) {
+	m.HandlerInvocations.WithLabelValues(handler).Inc()
+}
+
+func (m *Metrics) HandlerFailed(handler string) {
+	m.HandlerErrors.WithLabelValues(handler).Inc()
+}
+
+func (m *Metrics) MessageSent(result string) {
+	m.Sends.WithLabelValues(result).Inc()
+}
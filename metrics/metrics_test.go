@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lxfontes/jarbas/chat"
+	"github.com/lxfontes/jarbas/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+type testItem struct {
+	ID string `json:"id"`
+}
+
+func (ti *testItem) StoreID() string {
+	return ti.ID
+}
+
+func (ti *testItem) StoreExpires() time.Time {
+	return time.Time{}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	assert.Nil(t, err)
+
+	m.MessageReceived()
+	m.HandlerInvoked("ping")
+	m.HandlerFailed("ping")
+	m.MessageSent(chat.SendOK)
+
+	s := InstrumentStore(store.NewMemoryStore(), m)
+	assert.Nil(t, s.Namespace("test").Save(&testItem{ID: "a"}))
+
+	rr := httptest.NewRecorder()
+	Handler(reg).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rr.Body.String()
+	for _, line := range []string{
+		"jarbas_messages_received_total 1",
+		`jarbas_handler_invocations_total{handler="ping"} 1`,
+		`jarbas_handler_errors_total{handler="ping"} 1`,
+		`jarbas_messages_sent_total{result="ok"} 1`,
+		`jarbas_store_duration_seconds_count{op="save"} 1`,
+	} {
+		assert.True(t, strings.Contains(body, line), line)
+	}
+}
+
+func TestMetricsRegisterTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := New(reg)
+	assert.Nil(t, err)
+
+	_, err = New(reg)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/lxfontes/jarbas/store"
+)
+
+// InstrumentStore times every namespace operation on s
+func InstrumentStore(s store.Store, m *Metrics) store.Store {
+	return store.NewObservableStore(s, store.Hooks{
+		AfterOp: func(op store.Op, took time.Duration, err error) {
+			m.StoreLatency.WithLabelValues(op.Name).Observe(took.Seconds())
+		},
+	})
+}
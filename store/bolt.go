@@ -0,0 +1,692 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// stack entries read per transaction by All
+const boltAllChunk = 100
+
+var (
+	boltItemsBucket  = []byte("items")
+	boltStacksBucket = []byte("stacks")
+	boltSetsBucket   = []byte("sets")
+)
+
+// boltItem nests raw json, items are always json encoded
+var boltCodec Codec = errorCodec{codec: JSONCodec}
+
+type boltStore struct {
+	db *bolt.DB
+
+	// bolt files are locked to a single process, pushes wake up BPop in-process
+	mtx    sync.Mutex
+	pushed *sync.Cond
+}
+
+type boltNamespace struct {
+	boltStore *boltStore
+	namespace []byte
+}
+
+// boltItem wraps stored items so we can honor StoreExpires
+type boltItem struct {
+	Expires time.Time       `json:"expires"`
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func (bi *boltItem) expired() bool {
+	return !bi.Expires.IsZero() && time.Now().After(bi.Expires)
+}
+
+// bucket returns the sub-bucket 'name' for this namespace, nil if it doesn't exist yet
+func (bn *boltNamespace) bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	root := tx.Bucket(bn.namespace)
+	if root == nil {
+		return nil
+	}
+
+	return root.Bucket(name)
+}
+
+func (bn *boltNamespace) createBucket(tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	root, err := tx.CreateBucketIfNotExists(bn.namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return root.CreateBucketIfNotExists(name)
+}
+
+func (bn *boltNamespace) FindByID(id string, out interface{}) error {
+	var item boltItem
+	err := bn.boltStore.view(func(tx *bolt.Tx) error {
+		items := bn.bucket(tx, boltItemsBucket)
+		if items == nil {
+			return ErrItemNotFound
+		}
+
+		rawItem := items.Get([]byte(id))
+		if rawItem == nil {
+			return ErrItemNotFound
+		}
+
+		return boltCodec.Unmarshal(rawItem, &item)
+	})
+	if err != nil {
+		return err
+	}
+
+	if item.expired() {
+		// lazily purge expired items
+		bn.purgeExpired([]string{id})
+		return ErrItemNotFound
+	}
+
+	return boltCodec.Unmarshal(item.Data, out)
+}
+
+func (bn *boltNamespace) Exists(id string) (bool, error) {
+	var raw json.RawMessage
+	err := bn.FindByID(id, &raw)
+	switch err {
+	case nil:
+		return true, nil
+	case ErrItemNotFound:
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (bn *boltNamespace) Save(item Storable) error {
+	return bn.save(item, func(version int) error { return nil })
+}
+
+func (bn *boltNamespace) SaveIfVersion(item Storable, expectedVersion int) error {
+	return bn.save(item, func(version int) error {
+		if version != expectedVersion {
+			return ErrVersionConflict
+		}
+
+		return nil
+	})
+}
+
+// save writes item after 'check' accepts the currently stored version
+func (bn *boltNamespace) save(item Storable, check func(version int) error) error {
+	data, err := boltCodec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	return bn.boltStore.update(func(tx *bolt.Tx) error {
+		items, err := bn.createBucket(tx, boltItemsBucket)
+		if err != nil {
+			return err
+		}
+
+		return bn.put(items, item, data, check)
+	})
+}
+
+func (bn *boltNamespace) put(items *bolt.Bucket, item Storable, data []byte, check func(version int) error) error {
+	version, err := bn.version(items, item.StoreID())
+	if err != nil {
+		return err
+	}
+
+	if err = check(version); err != nil {
+		return err
+	}
+
+	rawItem, err := boltCodec.Marshal(&boltItem{
+		Expires: item.StoreExpires(),
+		Version: version + 1,
+		Data:    data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return items.Put([]byte(item.StoreID()), rawItem)
+}
+
+// SaveAll runs in a single transaction, any failure rolls back the whole batch
+func (bn *boltNamespace) SaveAll(batch []Storable) error {
+	return bn.boltStore.update(func(tx *bolt.Tx) error {
+		items, err := bn.createBucket(tx, boltItemsBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range batch {
+			data, err := boltCodec.Marshal(item)
+			if err == nil {
+				err = bn.put(items, item, data, func(version int) error { return nil })
+			}
+
+			if err != nil {
+				return &SaveAllError{ID: item.StoreID(), Err: err}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (bn *boltNamespace) Version(id string) (int, error) {
+	version := 0
+	err := bn.boltStore.view(func(tx *bolt.Tx) error {
+		items := bn.bucket(tx, boltItemsBucket)
+		if items == nil {
+			return nil
+		}
+
+		var err error
+		version, err = bn.version(items, id)
+		return err
+	})
+
+	return version, err
+}
+
+func (bn *boltNamespace) version(items *bolt.Bucket, id string) (int, error) {
+	rawItem := items.Get([]byte(id))
+	if rawItem == nil {
+		return 0, nil
+	}
+
+	var item boltItem
+	if err := boltCodec.Unmarshal(rawItem, &item); err != nil {
+		return 0, err
+	}
+
+	if item.expired() {
+		return 0, nil
+	}
+
+	return item.Version, nil
+}
+
+func (bn *boltNamespace) Delete(id string) error {
+	return bn.boltStore.update(func(tx *bolt.Tx) error {
+		items := bn.bucket(tx, boltItemsBucket)
+		if items == nil {
+			return nil
+		}
+
+		return items.Delete([]byte(id))
+	})
+}
+
+func (bn *boltNamespace) Keys() ([]string, error) {
+	keys := []string{}
+	expired := []string{}
+	err := bn.boltStore.view(func(tx *bolt.Tx) error {
+		items := bn.bucket(tx, boltItemsBucket)
+		if items == nil {
+			return nil
+		}
+
+		return items.ForEach(func(k []byte, v []byte) error {
+			var item boltItem
+			if err := boltCodec.Unmarshal(v, &item); err != nil {
+				return err
+			}
+
+			if item.expired() {
+				expired = append(expired, string(k))
+				return nil
+			}
+
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(expired) > 0 {
+		bn.purgeExpired(expired)
+	}
+
+	return keys, nil
+}
+
+// purgeExpired deletes ids that are still expired, they may have been saved again since we looked
+func (bn *boltNamespace) purgeExpired(ids []string) error {
+	return bn.boltStore.update(func(tx *bolt.Tx) error {
+		items := bn.bucket(tx, boltItemsBucket)
+		if items == nil {
+			return nil
+		}
+
+		for _, id := range ids {
+			rawItem := items.Get([]byte(id))
+			if rawItem == nil {
+				continue
+			}
+
+			var item boltItem
+			if err := boltCodec.Unmarshal(rawItem, &item); err != nil {
+				return err
+			}
+
+			if !item.expired() {
+				continue
+			}
+
+			if err := items.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (bn *boltNamespace) Incr(id string, delta int64) (int64, error) {
+	var counter int64
+	err := bn.boltStore.update(func(tx *bolt.Tx) error {
+		items, err := bn.createBucket(tx, boltItemsBucket)
+		if err != nil {
+			return err
+		}
+
+		var item boltItem
+		if rawItem := items.Get([]byte(id)); rawItem != nil {
+			if err = boltCodec.Unmarshal(rawItem, &item); err != nil {
+				return err
+			}
+		}
+
+		if item.expired() {
+			item = boltItem{}
+		}
+
+		if item.Data != nil {
+			if err = boltCodec.Unmarshal(item.Data, &counter); err != nil {
+				return err
+			}
+		}
+
+		counter += delta
+		if item.Data, err = boltCodec.Marshal(counter); err != nil {
+			return err
+		}
+
+		rawItem, err := boltCodec.Marshal(&item)
+		if err != nil {
+			return err
+		}
+
+		return items.Put([]byte(id), rawItem)
+	})
+
+	return counter, err
+}
+
+func (bn *boltNamespace) Push(stack string, item Storable) error {
+	return bn.PushWithTTL(stack, item, 0)
+}
+
+func (bn *boltNamespace) PushWithTTL(stack string, item Storable, ttl time.Duration) error {
+	data, err := boltCodec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	entry := &boltItem{
+		Data: data,
+	}
+
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+
+	rawItem, err := boltCodec.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = bn.boltStore.update(func(tx *bolt.Tx) error {
+		stacks, err := bn.createBucket(tx, boltStacksBucket)
+		if err != nil {
+			return err
+		}
+
+		items, err := stacks.CreateBucketIfNotExists([]byte(stack))
+		if err != nil {
+			return err
+		}
+
+		seq, err := items.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		return items.Put(boltKey(seq), rawItem)
+	})
+	if err != nil {
+		return err
+	}
+
+	bn.boltStore.mtx.Lock()
+	bn.boltStore.pushed.Broadcast()
+	bn.boltStore.mtx.Unlock()
+
+	return nil
+}
+
+func (bn *boltNamespace) Pop(stack string, out interface{}) error {
+	var entry boltItem
+	err := bn.boltStore.update(func(tx *bolt.Tx) error {
+		items := bn.stackBucket(tx, stack)
+		if items == nil {
+			return ErrItemNotFound
+		}
+
+		// expired entries are dropped on the way
+		cursor := items.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.First() {
+			if err := boltCodec.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+
+			if !entry.expired() {
+				return nil
+			}
+		}
+
+		return ErrItemNotFound
+	})
+	if err != nil {
+		return err
+	}
+
+	return boltCodec.Unmarshal(entry.Data, out)
+}
+
+func (bn *boltNamespace) BPop(stack string, timeout time.Duration, out interface{}) error {
+	bs := bn.boltStore
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			bs.mtx.Lock()
+			bs.pushed.Broadcast()
+			bs.mtx.Unlock()
+		})
+		defer timer.Stop()
+	}
+
+	// pushes commit before broadcasting, holding mtx while popping can't miss them
+	for {
+		err := bn.Pop(stack, out)
+		if err != ErrItemNotFound {
+			return err
+		}
+
+		if timeout > 0 && !time.Now().Before(deadline) {
+			return err
+		}
+
+		bs.pushed.Wait()
+	}
+}
+
+// Peek skips expired entries, Pop drops them later on
+func (bn *boltNamespace) Peek(stack string, out interface{}) error {
+	var entry boltItem
+	err := bn.boltStore.view(func(tx *bolt.Tx) error {
+		items := bn.stackBucket(tx, stack)
+		if items == nil {
+			return ErrItemNotFound
+		}
+
+		cursor := items.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if err := boltCodec.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			if !entry.expired() {
+				return nil
+			}
+		}
+
+		return ErrItemNotFound
+	})
+	if err != nil {
+		return err
+	}
+
+	return boltCodec.Unmarshal(entry.Data, out)
+}
+
+// All reads the stack in chunks of boltAllChunk entries, callbacks run outside transactions
+// since they might call back into the store
+func (bn *boltNamespace) All(stack string, cb func(out []byte) error) error {
+	var after []byte
+	for {
+		entries, next, err := bn.stackChunk(stack, after)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err = cb(entry); err != nil {
+				return err
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		after = next
+	}
+}
+
+// stackChunk returns live entries from the next boltAllChunk keys after 'after' (nil means from the start),
+// next is the key to resume from or nil once the stack is exhausted
+func (bn *boltNamespace) stackChunk(stack string, after []byte) (entries [][]byte, next []byte, err error) {
+	err = bn.boltStore.view(func(tx *bolt.Tx) error {
+		items := bn.stackBucket(tx, stack)
+		if items == nil {
+			// unknown or dropped since the previous chunk
+			return nil
+		}
+
+		cursor := items.Cursor()
+		k, v := cursor.First()
+		if after != nil {
+			k, v = cursor.Seek(after)
+			if bytes.Equal(k, after) {
+				k, v = cursor.Next()
+			}
+		}
+
+		for scanned := 0; k != nil; k, v = cursor.Next() {
+			var entry boltItem
+			if err := boltCodec.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			if !entry.expired() {
+				entries = append(entries, entry.Data)
+			}
+
+			if scanned++; scanned == boltAllChunk {
+				// keys are only valid within the transaction
+				next = append([]byte{}, k...)
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return entries, next, err
+}
+
+func (bn *boltNamespace) Count(stack string) (int, error) {
+	count := 0
+	err := bn.boltStore.view(func(tx *bolt.Tx) error {
+		items := bn.stackBucket(tx, stack)
+		if items == nil {
+			return nil
+		}
+
+		return items.ForEach(func(k []byte, v []byte) error {
+			var entry boltItem
+			if err := boltCodec.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			if !entry.expired() {
+				count++
+			}
+
+			return nil
+		})
+	})
+
+	return count, err
+}
+
+func (bn *boltNamespace) stackBucket(tx *bolt.Tx, stack string) *bolt.Bucket {
+	stacks := bn.bucket(tx, boltStacksBucket)
+	if stacks == nil {
+		return nil
+	}
+
+	return stacks.Bucket([]byte(stack))
+}
+
+// SetAdd stores members as keys of a bucket per set, with empty values
+func (bn *boltNamespace) SetAdd(set string, member string) (bool, error) {
+	added := false
+	err := bn.boltStore.update(func(tx *bolt.Tx) error {
+		sets, err := bn.createBucket(tx, boltSetsBucket)
+		if err != nil {
+			return err
+		}
+
+		members, err := sets.CreateBucketIfNotExists([]byte(set))
+		if err != nil {
+			return err
+		}
+
+		if members.Get([]byte(member)) != nil {
+			return nil
+		}
+
+		added = true
+		return members.Put([]byte(member), []byte{})
+	})
+
+	return added, err
+}
+
+func (bn *boltNamespace) SetRemove(set string, member string) (bool, error) {
+	removed := false
+	err := bn.boltStore.update(func(tx *bolt.Tx) error {
+		members := bn.setBucket(tx, set)
+		if members == nil || members.Get([]byte(member)) == nil {
+			return nil
+		}
+
+		removed = true
+		return members.Delete([]byte(member))
+	})
+
+	return removed, err
+}
+
+func (bn *boltNamespace) SetMembers(set string) ([]string, error) {
+	members := []string{}
+	err := bn.boltStore.view(func(tx *bolt.Tx) error {
+		bucket := bn.setBucket(tx, set)
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k []byte, v []byte) error {
+			members = append(members, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (bn *boltNamespace) setBucket(tx *bolt.Tx, set string) *bolt.Bucket {
+	sets := bn.bucket(tx, boltSetsBucket)
+	if sets == nil {
+		return nil
+	}
+
+	return sets.Bucket([]byte(set))
+}
+
+// boltKey encodes sequences big endian so keys sort in insertion order
+func boltKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+var _ Store = &boltStore{}
+var _ Namespace = &boltNamespace{}
+
+func NewBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, backendError(err)
+	}
+
+	bs := &boltStore{
+		db: db,
+	}
+	bs.pushed = sync.NewCond(&bs.mtx)
+
+	return bs, nil
+}
+
+func (bs *boltStore) Namespace(name string) Namespace {
+	return &boltNamespace{
+		boltStore: bs,
+		namespace: []byte(name),
+	}
+}
+
+// view & update tag transaction failures with ErrBackend, codec errors are already tagged
+func (bs *boltStore) view(fn func(tx *bolt.Tx) error) error {
+	return backendError(bs.db.View(fn))
+}
+
+func (bs *boltStore) update(fn func(tx *bolt.Tx) error) error {
+	return backendError(bs.db.Update(fn))
+}
+
+func (bs *boltStore) Close() error {
+	return bs.db.Close()
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func boltTestStore(t *testing.T) (*boltStore, func()) {
+	dir, err := ioutil.TempDir("", "jarbas-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := NewBoltStore(filepath.Join(dir, "jarbas.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bs, func() {
+		bs.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBolt(t *testing.T) {
+	bs, cleanup := boltTestStore(t)
+	defer cleanup()
+
+	performStoreTest(t, bs)
+}
+
+func TestBoltReopen(t *testing.T) {
+	bs, cleanup := boltTestStore(t)
+	defer cleanup()
+
+	path := bs.db.Path()
+	if err := bs.Namespace("ns").Save(&stubItem{ID: "123", Thing: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	bs.Close()
+
+	reopened, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	var stored stubItem
+	err = reopened.Namespace("ns").FindByID("123", &stored)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", stored.Thing)
+}
+
+func TestBoltPurgeKeepsResaved(t *testing.T) {
+	bs, cleanup := boltTestStore(t)
+	defer cleanup()
+
+	namespace := bs.Namespace("ns").(*boltNamespace)
+	assert.Nil(t, namespace.Save(&stubItem{ID: "stale", expires: time.Now().Add(-time.Second)}))
+	assert.Nil(t, namespace.Save(&stubItem{ID: "gone", expires: time.Now().Add(-time.Second)}))
+
+	// saved again between a reader spotting it expired and the purge
+	assert.Nil(t, namespace.Save(&stubItem{ID: "stale", Thing: "fresh"}))
+	assert.Nil(t, namespace.purgeExpired([]string{"stale", "gone", "unknown"}))
+
+	var stored stubItem
+	assert.Nil(t, namespace.FindByID("stale", &stored))
+	assert.Equal(t, "fresh", stored.Thing)
+
+	keys, err := namespace.Keys()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"stale"}, keys)
+}
@@ -0,0 +1,37 @@
+package store
+
+import "encoding/json"
+
+// Codec controls how items are serialized by stores
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is the default codec for all stores
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct {
+}
+
+func (jc jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jc jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// errorCodec tags every codec failure with ErrSerialization
+type errorCodec struct {
+	codec Codec
+}
+
+func (ec errorCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := ec.codec.Marshal(v)
+	return data, serializationError(err)
+}
+
+func (ec errorCodec) Unmarshal(data []byte, v interface{}) error {
+	return serializationError(ec.codec.Unmarshal(data, v))
+}
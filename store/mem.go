@@ -1,8 +1,6 @@
 package store
 
 import (
-	"encoding/json"
-	"fmt"
 	"sync"
 	"time"
 )
@@ -10,35 +8,213 @@ import (
 var _ Store = &memStore{}
 var _ Namespace = storage{}
 
-type storage map[string][]byte
+type storageItem struct {
+	data    []byte
+	expires time.Time
+	version int
+}
+
+func (si *storageItem) expired() bool {
+	return !si.expires.IsZero() && time.Now().After(si.expires)
+}
+
+type storage struct {
+	items map[string]*storageItem
+	// itemStacks by name, kept apart so stack names can't clash with item ids
+	stacks map[string]*storageItem
+	// member sets by name, also apart from items
+	sets map[string]map[string]struct{}
+	// shared with the parent memStore
+	mtx    *sync.Mutex
+	pushed *sync.Cond // broadcast on every push, wakes up BPop
+	codec  Codec
+}
 
 func (s storage) FindByID(id string, out interface{}) error {
-	rawItem, ok := s[id]
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.findByID(id, out)
+}
+
+func (s storage) findByID(id string, out interface{}) error {
+	item, ok := s.get(id)
 	if !ok {
 		return ErrItemNotFound
 	}
 
-	return json.Unmarshal(rawItem, out)
+	return s.codec.Unmarshal(item.data, out)
+}
+
+// get lazily purges expired items
+func (s storage) get(id string) (*storageItem, bool) {
+	item, ok := s.items[id]
+	if !ok {
+		return nil, false
+	}
+
+	if item.expired() {
+		delete(s.items, id)
+		return nil, false
+	}
+
+	return item, true
+}
+
+func (s storage) Exists(id string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, ok := s.get(id)
+	return ok, nil
 }
 
 func (s storage) Delete(id string) error {
-	delete(s, id)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.items, id)
 	return nil
 }
 
 func (s storage) Save(item Storable) error {
-	rw, err := json.Marshal(item)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.save(item)
+}
+
+func (s storage) save(item Storable) error {
+	rw, err := s.codec.Marshal(item)
 	if err != nil {
 		return err
 	}
 
-	s[item.StoreID()] = rw
+	s.put(item, rw)
+	return nil
+}
+
+func (s storage) put(item Storable, rw []byte) {
+	version := 1
+	if existing, ok := s.get(item.StoreID()); ok {
+		version = existing.version + 1
+	}
+
+	s.items[item.StoreID()] = &storageItem{
+		data:    rw,
+		expires: item.StoreExpires(),
+		version: version,
+	}
+}
+
+func (s storage) SaveAll(items []Storable) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// marshal everything upfront so a failure leaves the namespace untouched
+	rws := make([][]byte, len(items))
+	for i, item := range items {
+		rw, err := s.codec.Marshal(item)
+		if err != nil {
+			return &SaveAllError{ID: item.StoreID(), Err: err}
+		}
+		rws[i] = rw
+	}
+
+	for i, item := range items {
+		s.put(item, rws[i])
+	}
+
 	return nil
 }
 
+func (s storage) Version(id string) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	item, ok := s.get(id)
+	if !ok {
+		return 0, nil
+	}
+
+	return item.version, nil
+}
+
+func (s storage) SaveIfVersion(item Storable, expectedVersion int) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	version := 0
+	if existing, ok := s.get(item.StoreID()); ok {
+		version = existing.version
+	}
+
+	if version != expectedVersion {
+		return ErrVersionConflict
+	}
+
+	return s.save(item)
+}
+
+func (s storage) Keys() ([]string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	keys := []string{}
+	for id, item := range s.items {
+		if item.expired() {
+			delete(s.items, id)
+			continue
+		}
+
+		keys = append(keys, id)
+	}
+
+	return keys, nil
+}
+
+func (s storage) Incr(id string, delta int64) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var counter int64
+	if err := s.findByID(id, &counter); err != nil && err != ErrItemNotFound {
+		return 0, err
+	}
+
+	counter += delta
+	rw, err := s.codec.Marshal(counter)
+	if err != nil {
+		return 0, err
+	}
+
+	item := &storageItem{
+		data: rw,
+	}
+
+	// keep expiration and version from a previous Save, like INCRBY does
+	if existing, ok := s.get(id); ok {
+		item.expires = existing.expires
+		item.version = existing.version
+	}
+
+	s.items[id] = item
+
+	return counter, nil
+}
+
+type stackEntry struct {
+	Data    []byte    `json:"data"`
+	Expires time.Time `json:"expires"`
+}
+
+func (se *stackEntry) expired() bool {
+	return !se.Expires.IsZero() && time.Now().After(se.Expires)
+}
+
 type itemStack struct {
-	ID    string   `json:"id"`
-	Items [][]byte `json:"items"`
+	ID    string       `json:"id"`
+	Items []stackEntry `json:"items"`
 }
 
 func (is *itemStack) StoreID() string {
@@ -49,55 +225,169 @@ func (is *itemStack) StoreExpires() time.Time {
 	return NeverExpire
 }
 
+// live returns entries that haven't expired yet
+func (is *itemStack) live() []stackEntry {
+	entries := []stackEntry{}
+	for _, entry := range is.Items {
+		if !entry.expired() {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// stackStorage stores itemStacks by stack name, sharing lock & codec with s
+func (s storage) stackStorage() storage {
+	return storage{
+		items: s.stacks,
+		mtx:   s.mtx,
+		codec: s.codec,
+	}
+}
+
 func (s storage) Push(stack string, item Storable) error {
-	keyName := fmt.Sprintf("_stack_%s", stack)
+	return s.PushWithTTL(stack, item, 0)
+}
+
+func (s storage) PushWithTTL(stack string, item Storable, ttl time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	stacks := s.stackStorage()
 	var is itemStack
 	var err error
-	if err = s.FindByID(keyName, &is); err != nil && err != ErrItemNotFound {
+	if err = stacks.findByID(stack, &is); err != nil && err != ErrItemNotFound {
 		return err
 	}
 
 	if err == ErrItemNotFound {
-		is.ID = keyName
-		is.Items = [][]byte{}
+		is.ID = stack
+		is.Items = []stackEntry{}
+	}
+
+	data, err := s.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	entry := stackEntry{
+		Data: data,
 	}
 
-	data, err := json.Marshal(item)
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+
+	is.Items = append(is.live(), entry)
 
-	is.Items = append(is.Items, data)
+	if err = stacks.save(&is); err != nil {
+		return err
+	}
 
-	return s.Save(&is)
+	s.pushed.Broadcast()
+	return nil
 }
 
 func (s storage) Pop(stack string, out interface{}) error {
-	keyName := fmt.Sprintf("_stack_%s", stack)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.pop(stack, out)
+}
+
+func (s storage) pop(stack string, out interface{}) error {
+	stacks := s.stackStorage()
 	var is itemStack
 	var err error
-	var rawItem []byte
-	if err = s.FindByID(keyName, &is); err != nil {
+	var entry stackEntry
+	if err = stacks.findByID(stack, &is); err != nil {
 		return err
 	}
 
-	rawItem, is.Items = is.Items[0], is.Items[1:]
+	is.Items = is.live()
+	if len(is.Items) == 0 {
+		return ErrItemNotFound
+	}
+
+	entry, is.Items = is.Items[0], is.Items[1:]
 
-	err = s.Save(&is)
+	err = stacks.save(&is)
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(rawItem, out)
+	return s.codec.Unmarshal(entry.Data, out)
+}
+
+// BPop waits up to timeout for an entry to pop, 0 waits forever. Returns ErrItemNotFound on timeout.
+func (s storage) BPop(stack string, timeout time.Duration, out interface{}) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	if timeout > 0 {
+		// wakes us up to give up, locking so the broadcast can't land between checking and waiting
+		timer := time.AfterFunc(timeout, func() {
+			s.mtx.Lock()
+			s.pushed.Broadcast()
+			s.mtx.Unlock()
+		})
+		defer timer.Stop()
+	}
+
+	for {
+		err := s.pop(stack, out)
+		if err != ErrItemNotFound {
+			return err
+		}
+
+		if timeout > 0 && !time.Now().Before(deadline) {
+			return err
+		}
+
+		s.pushed.Wait()
+	}
+}
+
+func (s storage) Peek(stack string, out interface{}) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var is itemStack
+	if err := s.stackStorage().findByID(stack, &is); err != nil {
+		return err
+	}
+
+	live := is.live()
+	if len(live) == 0 {
+		return ErrItemNotFound
+	}
+
+	return s.codec.Unmarshal(live[0].Data, out)
 }
 
 func (s storage) All(stack string, cb func(out []byte) error) error {
-	keyName := fmt.Sprintf("_stack_%s", stack)
+	s.mtx.Lock()
 	var is itemStack
-	var err error
-	if err = s.FindByID(keyName, &is); err != nil {
+	err := s.stackStorage().findByID(stack, &is)
+	// release before invoking callbacks, they might call back into the store
+	s.mtx.Unlock()
+
+	if err == ErrItemNotFound {
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 
-	for _, item := range is.Items {
-		if err = cb(item); err != nil {
+	// the stack is decoded in one go, at least skip copying live entries
+	for i := range is.Items {
+		if is.Items[i].expired() {
+			continue
+		}
+
+		if err = cb(is.Items[i].Data); err != nil {
 			return err
 		}
 	}
@@ -105,21 +395,194 @@ func (s storage) All(stack string, cb func(out []byte) error) error {
 	return nil
 }
 
+func (s storage) Count(stack string) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var is itemStack
+	err := s.stackStorage().findByID(stack, &is)
+	if err == ErrItemNotFound {
+		return 0, nil
+	}
+
+	return len(is.live()), err
+}
+
+func (s storage) SetAdd(set string, member string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	members, ok := s.sets[set]
+	if !ok {
+		members = map[string]struct{}{}
+		s.sets[set] = members
+	}
+
+	if _, ok := members[member]; ok {
+		return false, nil
+	}
+
+	members[member] = struct{}{}
+	return true, nil
+}
+
+func (s storage) SetRemove(set string, member string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	members := s.sets[set]
+	if _, ok := members[member]; !ok {
+		return false, nil
+	}
+
+	delete(members, member)
+	if len(members) == 0 {
+		delete(s.sets, set)
+	}
+
+	return true, nil
+}
+
+func (s storage) SetMembers(set string) ([]string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	members := []string{}
+	for member := range s.sets[set] {
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
 type memStore struct {
-	things map[string]storage
-	mtx    sync.Mutex
+	things     map[string]storage
+	mtx        sync.Mutex
+	codec      Codec
+	pushed     *sync.Cond
+	gcInterval time.Duration
+	stopGC     chan struct{}
+	stopOnce   sync.Once
+
+	snapshotPath string // saved on Close, see OpenMemoryStore
+}
+
+type memOpt func(*memStore)
+
+func WithMemoryCodec(codec Codec) memOpt {
+	return func(ms *memStore) {
+		ms.codec = codec
+	}
 }
 
-func NewMemoryStore() *memStore {
-	return &memStore{
+// WithGCInterval drops expired items every interval, instead of waiting for them to be accessed.
+// Call Close to stop the sweeper.
+func WithGCInterval(interval time.Duration) memOpt {
+	return func(ms *memStore) {
+		ms.gcInterval = interval
+	}
+}
+
+func NewMemoryStore(opts ...memOpt) *memStore {
+	ms := &memStore{
 		things: map[string]storage{},
+		codec:  JSONCodec,
+		stopGC: make(chan struct{}),
+	}
+	ms.pushed = sync.NewCond(&ms.mtx)
+
+	for _, opt := range opts {
+		opt(ms)
+	}
+	ms.codec = errorCodec{codec: ms.codec}
+
+	if ms.gcInterval > 0 {
+		go ms.gc()
+	}
+
+	return ms
+}
+
+// Close stops the WithGCInterval sweeper and saves stores opened with OpenMemoryStore.
+// The store remains usable.
+func (ms *memStore) Close() error {
+	ms.stopOnce.Do(func() { close(ms.stopGC) })
+
+	if ms.snapshotPath != "" {
+		return ms.SaveTo(ms.snapshotPath)
+	}
+
+	return nil
+}
+
+func (ms *memStore) gc() {
+	ticker := time.NewTicker(ms.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ms.sweep()
+		case <-ms.stopGC:
+			return
+		}
+	}
+}
+
+// sweep drops expired items from every namespace
+func (ms *memStore) sweep() {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
+	for _, namespace := range ms.things {
+		for id, item := range namespace.items {
+			if item.expired() {
+				delete(namespace.items, id)
+			}
+		}
+
+		namespace.sweepStacks()
+	}
+}
+
+// sweepStacks drops expired stack entries and stacks left empty, must be called with mtx held
+func (s storage) sweepStacks() {
+	stacks := s.stackStorage()
+	for name := range s.stacks {
+		var is itemStack
+		if err := stacks.findByID(name, &is); err != nil {
+			continue
+		}
+
+		live := is.live()
+		if len(live) == len(is.Items) {
+			continue
+		}
+
+		if len(live) == 0 {
+			delete(s.stacks, name)
+			continue
+		}
+
+		is.Items = live
+		stacks.save(&is)
 	}
 }
 
 func (ms *memStore) Namespace(name string) Namespace {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+
 	namespace, ok := ms.things[name]
 	if !ok {
-		namespace = storage{}
+		namespace = storage{
+			items:  map[string]*storageItem{},
+			stacks: map[string]*storageItem{},
+			sets:   map[string]map[string]struct{}{},
+			mtx:    &ms.mtx,
+			pushed: ms.pushed,
+			codec:  ms.codec,
+		}
 		ms.things[name] = namespace
 	}
 
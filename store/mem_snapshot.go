@@ -0,0 +1,156 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotItem is how storageItem is written to disk, data is still codec encoded
+type snapshotItem struct {
+	Data    []byte    `json:"data"`
+	Expires time.Time `json:"expires"`
+	Version int       `json:"version"`
+}
+
+// snapshotNamespace holds items and stacks by id, sets by name
+type snapshotNamespace struct {
+	Items  map[string]snapshotItem `json:"items"`
+	Stacks map[string]snapshotItem `json:"stacks"`
+	Sets   map[string][]string     `json:"sets"`
+}
+
+// memSnapshot is indexed by namespace
+type memSnapshot map[string]snapshotNamespace
+
+// OpenMemoryStore loads a store written by SaveTo, a missing file is an empty store.
+// Close saves the store back to path.
+func OpenMemoryStore(path string, opts ...memOpt) (*memStore, error) {
+	ms := NewMemoryStore(opts...)
+
+	if err := ms.LoadFrom(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		ms.Close()
+		return nil, err
+	}
+
+	ms.snapshotPath = path
+	return ms, nil
+}
+
+// SaveTo writes every namespace to path as json, expired items are left out
+func (ms *memStore) SaveTo(path string) error {
+	ms.mtx.Lock()
+	snapshot := memSnapshot{}
+	for name, namespace := range ms.things {
+		snapshot[name] = snapshotNamespace{
+			Items:  snapshotItems(namespace.items),
+			Stacks: snapshotItems(namespace.stacks),
+			Sets:   snapshotSets(namespace.sets),
+		}
+	}
+	ms.mtx.Unlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return serializationError(err)
+	}
+
+	// write & rename, a crash midway must not leave a truncated file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return backendError(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return backendError(err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return backendError(err)
+	}
+
+	return backendError(os.Rename(tmp.Name(), path))
+}
+
+// LoadFrom reads a file written by SaveTo, replacing items with the same id
+func (ms *memStore) LoadFrom(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return backendError(err)
+	}
+
+	snapshot := memSnapshot{}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return serializationError(err)
+	}
+
+	for name, saved := range snapshot {
+		// namespaces handed out earlier share the items map, fill it in place
+		namespace := ms.Namespace(name).(storage)
+
+		ms.mtx.Lock()
+		loadItems(namespace.items, saved.Items)
+		loadItems(namespace.stacks, saved.Stacks)
+		loadSets(namespace.sets, saved.Sets)
+		ms.pushed.Broadcast()
+		ms.mtx.Unlock()
+	}
+
+	return nil
+}
+
+// snapshotItems skips expired items, must be called with the store locked
+func snapshotItems(items map[string]*storageItem) map[string]snapshotItem {
+	snapshot := map[string]snapshotItem{}
+	for id, item := range items {
+		if item.expired() {
+			continue
+		}
+
+		snapshot[id] = snapshotItem{
+			Data:    item.data,
+			Expires: item.expires,
+			Version: item.version,
+		}
+	}
+
+	return snapshot
+}
+
+func loadItems(items map[string]*storageItem, snapshot map[string]snapshotItem) {
+	for id, item := range snapshot {
+		items[id] = &storageItem{
+			data:    item.Data,
+			expires: item.Expires,
+			version: item.Version,
+		}
+	}
+}
+
+func snapshotSets(sets map[string]map[string]struct{}) map[string][]string {
+	snapshot := map[string][]string{}
+	for name, members := range sets {
+		for member := range members {
+			snapshot[name] = append(snapshot[name], member)
+		}
+	}
+
+	return snapshot
+}
+
+func loadSets(sets map[string]map[string]struct{}, snapshot map[string][]string) {
+	for name, members := range snapshot {
+		if _, ok := sets[name]; !ok {
+			sets[name] = map[string]struct{}{}
+		}
+
+		for _, member := range members {
+			sets[name][member] = struct{}{}
+		}
+	}
+}
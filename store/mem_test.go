@@ -1,7 +1,213 @@
 package store
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
 
 func TestMemory(t *testing.T) {
 	performStoreTest(t, NewMemoryStore())
 }
+
+func TestMemoryCodec(t *testing.T) {
+	cc := &countingCodec{}
+	performStoreTest(t, NewMemoryStore(WithMemoryCodec(cc)))
+
+	assert.NotZero(t, cc.marshal)
+	assert.NotZero(t, cc.unmarshal)
+}
+
+func TestMemoryConcurrentPushPop(t *testing.T) {
+	namespace := NewMemoryStore().Namespace(uuid.New())
+	stack := uuid.New()
+	workers := 100
+
+	var wg sync.WaitGroup
+	popped := make(chan string, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			if err := namespace.Push(stack, &stubItem{ID: id}); err != nil {
+				t.Error(err)
+				return
+			}
+
+			var stored stubItem
+			if err := namespace.Pop(stack, &stored); err != nil {
+				t.Error(err)
+				return
+			}
+			popped <- stored.ID
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+	close(popped)
+
+	seen := map[string]bool{}
+	for id := range popped {
+		assert.False(t, seen[id], "popped twice: %s", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, workers)
+
+	var stored stubItem
+	assert.Equal(t, ErrItemNotFound, namespace.Pop(stack, &stored))
+}
+
+func TestMemoryParallelStoreTest(t *testing.T) {
+	s := NewMemoryStore()
+
+	// every run uses its own namespaces on the shared store
+	for i := 0; i < 4; i++ {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Parallel()
+			performStoreTest(t, s)
+		})
+	}
+}
+
+func TestMemorySharedNamespace(t *testing.T) {
+	s := NewMemoryStore()
+	name := uuid.New()
+	workers := 50
+
+	// namespaces with the same name share items, and the lock
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+
+			namespace := s.Namespace(name)
+			if err := namespace.Save(&stubItem{ID: id}); err != nil {
+				t.Error(err)
+			}
+			if _, err := namespace.Incr("counter", 1); err != nil {
+				t.Error(err)
+			}
+			if _, err := namespace.Keys(); err != nil {
+				t.Error(err)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	keys, err := s.Namespace(name).Keys()
+	assert.Nil(t, err)
+	assert.Len(t, keys, workers+1)
+
+	counter, err := s.Namespace(name).Incr("counter", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(workers), counter)
+}
+
+func TestMemoryGC(t *testing.T) {
+	s := NewMemoryStore(WithGCInterval(10 * time.Millisecond))
+	defer s.Close()
+
+	namespace := s.Namespace(uuid.New())
+	assert.Nil(t, namespace.Save(&stubItem{ID: "short", expires: time.Now().Add(5 * time.Millisecond)}))
+	assert.Nil(t, namespace.Save(&stubItem{ID: "forever"}))
+
+	time.Sleep(50 * time.Millisecond)
+
+	// swept without being accessed
+	s.mtx.Lock()
+	_, short := namespace.(storage).items["short"]
+	_, forever := namespace.(storage).items["forever"]
+	s.mtx.Unlock()
+
+	assert.False(t, short)
+	assert.True(t, forever)
+
+	assert.Nil(t, s.Close())
+	assert.Nil(t, s.Close())
+}
+
+func TestMemoryGCStacks(t *testing.T) {
+	s := NewMemoryStore(WithGCInterval(10 * time.Millisecond))
+	defer s.Close()
+
+	namespace := s.Namespace(uuid.New())
+	assert.Nil(t, namespace.PushWithTTL("gone", &stubItem{ID: "1"}, 5*time.Millisecond))
+	assert.Nil(t, namespace.PushWithTTL("mixed", &stubItem{ID: "2"}, 5*time.Millisecond))
+	assert.Nil(t, namespace.Push("mixed", &stubItem{ID: "3"}))
+
+	time.Sleep(50 * time.Millisecond)
+
+	// swept without being accessed
+	s.mtx.Lock()
+	stacks := namespace.(storage).stacks
+	_, gone := stacks["gone"]
+	var mixed itemStack
+	err := namespace.(storage).stackStorage().findByID("mixed", &mixed)
+	s.mtx.Unlock()
+
+	assert.False(t, gone)
+	if assert.Nil(t, err) {
+		assert.Len(t, mixed.Items, 1)
+	}
+
+	count, err := namespace.Count("mixed")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jarbas-mem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jarbas.json")
+
+	s, err := OpenMemoryStore(path)
+	assert.Nil(t, err)
+
+	namespace := s.Namespace("things")
+	assert.Nil(t, namespace.Save(&stubItem{ID: "123", Thing: "abc"}))
+	assert.Nil(t, namespace.Save(&stubItem{ID: "gone", expires: time.Now().Add(-time.Second)}))
+	assert.Nil(t, namespace.Push("stack", &stubItem{ID: "queued"}))
+	_, err = namespace.Incr("counter", 7)
+	assert.Nil(t, err)
+	_, err = namespace.SetAdd("links", "https://example.com")
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	reopened, err := OpenMemoryStore(path)
+	assert.Nil(t, err)
+	namespace = reopened.Namespace("things")
+
+	var stored stubItem
+	assert.Nil(t, namespace.FindByID("123", &stored))
+	assert.Equal(t, "abc", stored.Thing)
+
+	assert.Equal(t, ErrItemNotFound, namespace.FindByID("gone", &stored))
+
+	assert.Nil(t, namespace.Pop("stack", &stored))
+	assert.Equal(t, "queued", stored.ID)
+
+	counter, err := namespace.Incr("counter", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), counter)
+
+	members, err := namespace.SetMembers("links")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"https://example.com"}, members)
+
+	// garbage is an error, not an empty store
+	assert.Nil(t, ioutil.WriteFile(path, []byte("nope"), 0600))
+	_, err = OpenMemoryStore(path)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,154 @@
+package store
+
+import "time"
+
+// Op describes a namespace operation handed to Hooks
+type Op struct {
+	Name      string // ex: "find_by_id", "push"
+	Namespace string
+	Key       string // item id, stack or set name. Empty for Keys and SaveAll.
+}
+
+// Hooks are called around every namespace operation, nil callbacks are skipped
+type Hooks struct {
+	BeforeOp func(op Op)
+	AfterOp  func(op Op, took time.Duration, err error)
+}
+
+type observableStore struct {
+	inner Store
+	hooks Hooks
+}
+
+// NewObservableStore decorates inner, calling hooks around every namespace operation.
+// Use it for metrics, tracing or caching in front of any store.
+func NewObservableStore(inner Store, hooks Hooks) Store {
+	return &observableStore{inner: inner, hooks: hooks}
+}
+
+func (obs *observableStore) Namespace(name string) Namespace {
+	return &observableNamespace{
+		inner: obs.inner.Namespace(name),
+		name:  name,
+		hooks: obs.hooks,
+	}
+}
+
+type observableNamespace struct {
+	inner Namespace
+	name  string
+	hooks Hooks
+}
+
+// observe calls BeforeOp, the returned func calls AfterOp and is meant to be deferred
+func (on *observableNamespace) observe(name string, key string, err *error) func() {
+	op := Op{Name: name, Namespace: on.name, Key: key}
+	if on.hooks.BeforeOp != nil {
+		on.hooks.BeforeOp(op)
+	}
+
+	start := time.Now()
+	return func() {
+		if on.hooks.AfterOp != nil {
+			on.hooks.AfterOp(op, time.Since(start), *err)
+		}
+	}
+}
+
+func (on *observableNamespace) FindByID(id string, out interface{}) (err error) {
+	defer on.observe("find_by_id", id, &err)()
+	return on.inner.FindByID(id, out)
+}
+
+func (on *observableNamespace) Exists(id string) (exists bool, err error) {
+	defer on.observe("exists", id, &err)()
+	return on.inner.Exists(id)
+}
+
+func (on *observableNamespace) Save(item Storable) (err error) {
+	defer on.observe("save", item.StoreID(), &err)()
+	return on.inner.Save(item)
+}
+
+func (on *observableNamespace) Version(id string) (version int, err error) {
+	defer on.observe("version", id, &err)()
+	return on.inner.Version(id)
+}
+
+func (on *observableNamespace) SaveIfVersion(item Storable, expectedVersion int) (err error) {
+	defer on.observe("save_if_version", item.StoreID(), &err)()
+	return on.inner.SaveIfVersion(item, expectedVersion)
+}
+
+func (on *observableNamespace) SaveAll(items []Storable) (err error) {
+	defer on.observe("save_all", "", &err)()
+	return on.inner.SaveAll(items)
+}
+
+func (on *observableNamespace) Delete(id string) (err error) {
+	defer on.observe("delete", id, &err)()
+	return on.inner.Delete(id)
+}
+
+func (on *observableNamespace) Keys() (keys []string, err error) {
+	defer on.observe("keys", "", &err)()
+	return on.inner.Keys()
+}
+
+func (on *observableNamespace) Incr(id string, delta int64) (counter int64, err error) {
+	defer on.observe("incr", id, &err)()
+	return on.inner.Incr(id, delta)
+}
+
+func (on *observableNamespace) Push(stack string, item Storable) (err error) {
+	defer on.observe("push", stack, &err)()
+	return on.inner.Push(stack, item)
+}
+
+func (on *observableNamespace) PushWithTTL(stack string, item Storable, ttl time.Duration) (err error) {
+	defer on.observe("push_with_ttl", stack, &err)()
+	return on.inner.PushWithTTL(stack, item, ttl)
+}
+
+func (on *observableNamespace) Pop(stack string, out interface{}) (err error) {
+	defer on.observe("pop", stack, &err)()
+	return on.inner.Pop(stack, out)
+}
+
+func (on *observableNamespace) BPop(stack string, timeout time.Duration, out interface{}) (err error) {
+	defer on.observe("bpop", stack, &err)()
+	return on.inner.BPop(stack, timeout, out)
+}
+
+func (on *observableNamespace) Peek(stack string, out interface{}) (err error) {
+	defer on.observe("peek", stack, &err)()
+	return on.inner.Peek(stack, out)
+}
+
+func (on *observableNamespace) All(stack string, cb func(out []byte) error) (err error) {
+	defer on.observe("all", stack, &err)()
+	return on.inner.All(stack, cb)
+}
+
+func (on *observableNamespace) Count(stack string) (count int, err error) {
+	defer on.observe("count", stack, &err)()
+	return on.inner.Count(stack)
+}
+
+func (on *observableNamespace) SetAdd(set string, member string) (added bool, err error) {
+	defer on.observe("set_add", set, &err)()
+	return on.inner.SetAdd(set, member)
+}
+
+func (on *observableNamespace) SetRemove(set string, member string) (removed bool, err error) {
+	defer on.observe("set_remove", set, &err)()
+	return on.inner.SetRemove(set, member)
+}
+
+func (on *observableNamespace) SetMembers(set string) (members []string, err error) {
+	defer on.observe("set_members", set, &err)()
+	return on.inner.SetMembers(set)
+}
+
+var _ Store = &observableStore{}
+var _ Namespace = &observableNamespace{}
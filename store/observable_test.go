@@ -0,0 +1,52 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObservable(t *testing.T) {
+	performStoreTest(t, NewObservableStore(NewMemoryStore(), Hooks{}))
+}
+
+func TestObservableHooks(t *testing.T) {
+	var mtx sync.Mutex
+	before := []Op{}
+	after := map[string]error{}
+
+	s := NewObservableStore(NewMemoryStore(), Hooks{
+		BeforeOp: func(op Op) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			before = append(before, op)
+		},
+		AfterOp: func(op Op, took time.Duration, err error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			assert.True(t, took >= 0)
+			after[op.Name] = err
+		},
+	})
+
+	namespace := s.Namespace("things")
+	assert.Nil(t, namespace.Save(&stubItem{ID: "123"}))
+
+	var stored stubItem
+	assert.Equal(t, ErrItemNotFound, namespace.FindByID("gone", &stored))
+	assert.Nil(t, namespace.Push("stack", &stubItem{ID: "456"}))
+
+	assert.Equal(t, []Op{
+		{Name: "save", Namespace: "things", Key: "123"},
+		{Name: "find_by_id", Namespace: "things", Key: "gone"},
+		{Name: "push", Namespace: "things", Key: "stack"},
+	}, before)
+
+	assert.Equal(t, map[string]error{
+		"save":       nil,
+		"find_by_id": ErrItemNotFound,
+		"push":       nil,
+	}, after)
+}
@@ -1,19 +1,36 @@
 package store
 
 import (
-	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 )
 
 const (
-	// max idle connections in the pool
+	// max idle connections in the pool, used when WithMaxIdle is not given
 	redisMaxIdle = 5
+	// idle connections older than this are pinged before being handed out
+	redisBorrowCheck = time.Minute
+	// stack entries fetched at once by All
+	redisAllChunk = 100
 )
 
 type redisStore struct {
 	pool *redis.Pool
+
+	addr     string
+	password string
+	db       int
+	codec    Codec
+
+	maxIdle     int
+	maxActive   int
+	idleTimeout time.Duration
+	wait        bool
+
+	migrateNamespaces []string
 }
 
 type redisNamespace struct {
@@ -25,8 +42,27 @@ func (rn *redisNamespace) keyFor(k string) string {
 	return fmt.Sprintf("%s:%s", rn.namespace, k)
 }
 
+// versionKeyFor lives outside the 'namespace:' prefix so no item id can overwrite it,
+// it expires along with the item
+func (rn *redisNamespace) versionKeyFor(id string) string {
+	return fmt.Sprintf("%s/version:%s", rn.namespace, id)
+}
+
+// setKeyFor lives outside the 'namespace:' prefix, like stackKeyFor
+func (rn *redisNamespace) setKeyFor(set string) string {
+	return fmt.Sprintf("%s/set:%s", rn.namespace, set)
+}
+
+// stackKeyFor lives outside the 'namespace:' prefix so stacks can't clash with items
+func (rn *redisNamespace) stackKeyFor(stack string) string {
+	return fmt.Sprintf("%s/stack:%s", rn.namespace, stack)
+}
+
 func (rn *redisNamespace) FindByID(id string, out interface{}) error {
-	client := rn.redisStore.conn()
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
 	defer client.Close()
 
 	resp, err := client.Do("GET", rn.keyFor(id))
@@ -42,51 +78,289 @@ func (rn *redisNamespace) FindByID(id string, out interface{}) error {
 		return err
 	}
 
-	return json.Unmarshal(rawItem, out)
+	return rn.redisStore.codec.Unmarshal(rawItem, out)
+}
+
+func (rn *redisNamespace) Exists(id string) (bool, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	return redis.Bool(client.Do("EXISTS", rn.keyFor(id)))
 }
 
 func (rn *redisNamespace) Save(item Storable) error {
-	client := rn.redisStore.conn()
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
 	defer client.Close()
 
-	rawItem, err := json.Marshal(item)
+	rawItem, err := rn.redisStore.codec.Marshal(item)
 	if err != nil {
 		return err
 	}
 
-	_, err = client.Do("SET", rn.keyFor(item.StoreID()), rawItem)
+	client.Send("MULTI")
+	rn.sendSave(client, item, rawItem)
+	replies, err := execReplies(client)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return firstError(replies)
 }
 
-func (rn *redisNamespace) Delete(id string) error {
-	client := rn.redisStore.conn()
+func (rn *redisNamespace) SaveAll(items []Storable) error {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
 	defer client.Close()
 
-	_, err := client.Do("DEL", rn.keyFor(id))
+	rawItems := make([][]byte, len(items))
+	for i, item := range items {
+		rawItem, err := rn.redisStore.codec.Marshal(item)
+		if err != nil {
+			return &SaveAllError{ID: item.StoreID(), Err: err}
+		}
+		rawItems[i] = rawItem
+	}
+
+	client.Send("MULTI")
+	commands := make([]int, len(items))
+	for i, item := range items {
+		commands[i] = rn.sendSave(client, item, rawItems[i])
+	}
+	replies, err := execReplies(client)
+	if err != nil {
+		return err
+	}
 
+	// redis keeps going past failed commands, report every item that didn't make it
+	saveErr := &SaveAllError{}
+	for i, item := range items {
+		err := firstError(replies[:commands[i]])
+		replies = replies[commands[i]:]
+		if err == nil {
+			continue
+		}
+
+		if saveErr.Err == nil {
+			saveErr.ID, saveErr.Err = item.StoreID(), err
+		}
+		saveErr.IDs = append(saveErr.IDs, item.StoreID())
+	}
+
+	if saveErr.Err != nil {
+		return saveErr
+	}
+
+	return nil
+}
+
+// sendSave queues the item and its version bump, must be called inside MULTI.
+// Returns how many commands were queued.
+func (rn *redisNamespace) sendSave(client redis.Conn, item Storable, rawItem []byte) int {
+	key := rn.keyFor(item.StoreID())
+	versionKey := rn.versionKeyFor(item.StoreID())
+	client.Send("SET", key, rawItem)
+	client.Send("INCR", versionKey)
+
+	// PEXPIREAT in the past removes the keys
+	if expires := item.StoreExpires(); !expires.IsZero() {
+		expiresAt := expires.UnixNano() / int64(time.Millisecond)
+		client.Send("PEXPIREAT", key, expiresAt)
+		client.Send("PEXPIREAT", versionKey, expiresAt)
+		return 4
+	}
+
+	// SET cleared the item's expiration, INCR keeps the version's
+	client.Send("PERSIST", versionKey)
+	return 3
+}
+
+func (rn *redisNamespace) Version(id string) (int, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	return rn.version(client, id)
+}
+
+func (rn *redisNamespace) version(client redis.Conn, id string) (int, error) {
+	exists, err := redis.Bool(client.Do("EXISTS", rn.keyFor(id)))
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	version, err := redis.Int(client.Do("GET", rn.versionKeyFor(id)))
+	if err == redis.ErrNil {
+		// saved before versions were tracked
+		return 0, nil
+	}
+
+	return version, err
+}
+
+func (rn *redisNamespace) SaveIfVersion(item Storable, expectedVersion int) error {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	rawItem, err := rn.redisStore.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	// any write to the item key between WATCH and EXEC aborts the transaction
+	if _, err = client.Do("WATCH", rn.keyFor(item.StoreID())); err != nil {
+		return err
+	}
+
+	version, err := rn.version(client, item.StoreID())
+	if err != nil {
+		client.Do("UNWATCH")
+		return err
+	}
+
+	if version != expectedVersion {
+		client.Do("UNWATCH")
+		return ErrVersionConflict
+	}
+
+	client.Send("MULTI")
+	rn.sendSave(client, item, rawItem)
+	replies, err := execReplies(client)
+	if err != nil {
+		return err
+	}
+
+	if replies == nil {
+		return ErrVersionConflict
+	}
+
+	return firstError(replies)
+}
+
+func (rn *redisNamespace) Delete(id string) error {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.Do("DEL", rn.keyFor(id), rn.versionKeyFor(id))
 	return err
 }
 
+func (rn *redisNamespace) Keys() ([]string, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	prefix := rn.keyFor("")
+	keys := []string{}
+	cursor := 0
+	for {
+		values, err := redis.Values(client.Do("SCAN", cursor, "MATCH", prefix+"*"))
+		if err != nil {
+			return nil, err
+		}
+
+		var page []string
+		if _, err = redis.Scan(values, &cursor, &page); err != nil {
+			return nil, err
+		}
+
+		for _, key := range page {
+			keys = append(keys, strings.TrimPrefix(key, prefix))
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	return keys, nil
+}
+
+// Incr leaves the codec out, INCRBY only works on plain numbers
+func (rn *redisNamespace) Incr(id string, delta int64) (int64, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	return redis.Int64(client.Do("INCRBY", rn.keyFor(id), delta))
+}
+
 func (rn *redisNamespace) Push(stack string, item Storable) error {
-	client := rn.redisStore.conn()
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
 	defer client.Close()
 
-	rawItem, err := json.Marshal(item)
+	rawItem, err := rn.redisStore.codec.Marshal(item)
 	if err != nil {
 		return err
 	}
 
-	_, err = client.Do("RPUSH", rn.keyFor(stack), rawItem)
+	_, err = client.Do("RPUSH", rn.stackKeyFor(stack), rawItem)
 
 	return err
 }
 
+// PushWithTTL expires the whole list, every push extends its lifetime. A ttl <= 0 never expires.
+func (rn *redisNamespace) PushWithTTL(stack string, item Storable, ttl time.Duration) error {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	rawItem, err := rn.redisStore.codec.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	client.Send("MULTI")
+	client.Send("RPUSH", rn.stackKeyFor(stack), rawItem)
+	// 0 means no expiration, like the other stores. PEXPIRE 0 would drop the whole list.
+	if ttl > 0 {
+		client.Send("PEXPIRE", rn.stackKeyFor(stack), redisMillis(ttl))
+	}
+	replies, err := execReplies(client)
+	if err != nil {
+		return err
+	}
+
+	return firstError(replies)
+}
+
+// redisMillis rounds up, a sub-millisecond ttl must not become 0
+func redisMillis(ttl time.Duration) int64 {
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
 func (rn *redisNamespace) Pop(stack string, out interface{}) error {
-	client := rn.redisStore.conn()
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
 	defer client.Close()
 
-	resp, err := client.Do("LPOP", rn.keyFor(stack))
+	resp, err := client.Do("LPOP", rn.stackKeyFor(stack))
 	if err != nil {
 		return err
 	}
@@ -99,24 +373,274 @@ func (rn *redisNamespace) Pop(stack string, out interface{}) error {
 		return err
 	}
 
-	return json.Unmarshal(rawItem, out)
+	return rn.redisStore.codec.Unmarshal(rawItem, out)
+}
+
+// BPop holds a pooled connection while waiting, timeout is rounded up to whole seconds
+func (rn *redisNamespace) BPop(stack string, timeout time.Duration, out interface{}) error {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// redis rejects negative timeouts, 0 blocks forever there too
+	if timeout < 0 {
+		timeout = 0
+	}
+	seconds := int64((timeout + time.Second - 1) / time.Second)
+
+	// replies with [key, value], nil on timeout
+	reply, err := redis.ByteSlices(client.Do("BLPOP", rn.stackKeyFor(stack), seconds))
+	if err != nil {
+		if err == redis.ErrNil {
+			return ErrItemNotFound
+		}
+		return err
+	}
+
+	return rn.redisStore.codec.Unmarshal(reply[1], out)
+}
+
+func (rn *redisNamespace) Peek(stack string, out interface{}) error {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	rawItem, err := redis.Bytes(client.Do("LINDEX", rn.stackKeyFor(stack), 0))
+	if err != nil {
+		if err == redis.ErrNil {
+			return ErrItemNotFound
+		}
+		return err
+	}
+
+	return rn.redisStore.codec.Unmarshal(rawItem, out)
 }
 
+// All reads the stack in windows of redisAllChunk, the connection is released while callbacks run.
+// Entries pushed or popped meanwhile might be missed or seen twice.
 func (rn *redisNamespace) All(stack string, cb func(out []byte) error) error {
+	for start := 0; ; start += redisAllChunk {
+		rawItems, err := rn.lrange(stack, start, start+redisAllChunk-1)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range rawItems {
+			if err = cb(item); err != nil {
+				return err
+			}
+		}
+
+		if len(rawItems) < redisAllChunk {
+			return nil
+		}
+	}
+}
+
+func (rn *redisNamespace) lrange(stack string, start int, stop int) ([][]byte, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return redis.ByteSlices(client.Do("LRANGE", rn.stackKeyFor(stack), start, stop))
+}
+
+func (rn *redisNamespace) Count(stack string) (int, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	return redis.Int(client.Do("LLEN", rn.stackKeyFor(stack)))
+}
+
+func (rn *redisNamespace) SetAdd(set string, member string) (bool, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	return redis.Bool(client.Do("SADD", rn.setKeyFor(set), member))
+}
+
+func (rn *redisNamespace) SetRemove(set string, member string) (bool, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	return redis.Bool(client.Do("SREM", rn.setKeyFor(set), member))
+}
+
+func (rn *redisNamespace) SetMembers(set string) ([]string, error) {
+	client, err := rn.redisStore.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	return redis.Strings(client.Do("SMEMBERS", rn.setKeyFor(set)))
+}
+
+// execReplies runs EXEC, failed commands come back as redis.Error in their reply slot.
+// Replies are nil when a WATCHed key changed and the transaction was discarded.
+func execReplies(client redis.Conn) ([]interface{}, error) {
+	replies, err := redis.Values(client.Do("EXEC"))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+
+	return replies, err
+}
+
+// firstError returns the first failed command among EXEC replies
+func firstError(replies []interface{}) error {
+	for _, reply := range replies {
+		if err, ok := reply.(redis.Error); ok {
+			return backendError(err)
+		}
+	}
+
 	return nil
 }
 
 var _ Store = &redisStore{}
 var _ Namespace = &redisNamespace{}
 
-// TODO: hostname selector
-func NewRedisStore() (*redisStore, error) {
-	addr := "localhost:6379"
-	dialer := func() (redis.Conn, error) { return redis.Dial("tcp", addr) }
+const (
+	// used when WithAddr is not given
+	redisDefaultAddr = "localhost:6379"
+)
+
+type redisOpt func(*redisStore)
+
+func WithAddr(addr string) redisOpt {
+	return func(rs *redisStore) {
+		rs.addr = addr
+	}
+}
+
+func WithPassword(password string) redisOpt {
+	return func(rs *redisStore) {
+		rs.password = password
+	}
+}
+
+func WithDB(db int) redisOpt {
+	return func(rs *redisStore) {
+		rs.db = db
+	}
+}
+
+// WithCodec serializes items and stack entries with codec, counters stay plain numbers
+func WithCodec(codec Codec) redisOpt {
+	return func(rs *redisStore) {
+		rs.codec = codec
+	}
+}
+
+// WithMaxIdle sets how many idle connections the pool keeps around
+func WithMaxIdle(maxIdle int) redisOpt {
+	return func(rs *redisStore) {
+		rs.maxIdle = maxIdle
+	}
+}
+
+// WithMaxActive caps open connections, 0 means no limit
+func WithMaxActive(maxActive int) redisOpt {
+	return func(rs *redisStore) {
+		rs.maxActive = maxActive
+	}
+}
+
+// WithIdleTimeout closes connections idle for longer than timeout, 0 keeps them forever
+func WithIdleTimeout(timeout time.Duration) redisOpt {
+	return func(rs *redisStore) {
+		rs.idleTimeout = timeout
+	}
+}
+
+// WithPoolWait blocks callers until a connection is released once WithMaxActive is reached,
+// instead of failing right away
+func WithPoolWait(wait bool) redisOpt {
+	return func(rs *redisStore) {
+		rs.wait = wait
+	}
+}
+
+// WithMigration also moves stacks written by older versions for namespaces that never saved an item.
+// Migration runs once per database, namespaces given after that are left alone.
+func WithMigration(namespaces ...string) redisOpt {
+	return func(rs *redisStore) {
+		rs.migrateNamespaces = append(rs.migrateNamespaces, namespaces...)
+	}
+}
+
+func NewRedisStore(opts ...redisOpt) (*redisStore, error) {
+	rs := &redisStore{
+		addr:    redisDefaultAddr,
+		codec:   JSONCodec,
+		maxIdle: redisMaxIdle,
+	}
+
+	for _, opt := range opts {
+		opt(rs)
+	}
+	rs.codec = errorCodec{codec: rs.codec}
+
+	rs.pool = &redis.Pool{
+		Dial:         rs.dial,
+		TestOnBorrow: testOnBorrow,
+		MaxIdle:      rs.maxIdle,
+		MaxActive:    rs.maxActive,
+		IdleTimeout:  rs.idleTimeout,
+		Wait:         rs.wait,
+	}
+
+	// fail early on misconfiguration (bad address, auth, db)
+	if err := rs.Ping(); err != nil {
+		rs.pool.Close()
+		return nil, err
+	}
+
+	if err := rs.migrate(); err != nil {
+		rs.pool.Close()
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+func (rs *redisStore) dial() (redis.Conn, error) {
+	dialOpts := []redis.DialOption{}
+	if rs.password != "" {
+		dialOpts = append(dialOpts, redis.DialPassword(rs.password))
+	}
+
+	if rs.db != 0 {
+		dialOpts = append(dialOpts, redis.DialDatabase(rs.db))
+	}
 
-	return &redisStore{
-		pool: redis.NewPool(dialer, redisMaxIdle),
-	}, nil
+	return redis.Dial("tcp", rs.addr, dialOpts...)
+}
+
+// testOnBorrow pings connections that sat idle for a while, the pool drops them on error
+func testOnBorrow(c redis.Conn, lastUsed time.Time) error {
+	if time.Since(lastUsed) < redisBorrowCheck {
+		return nil
+	}
+
+	_, err := c.Do("PING")
+	return err
 }
 
 func (rs *redisStore) Namespace(name string) Namespace {
@@ -126,6 +650,41 @@ func (rs *redisStore) Namespace(name string) Namespace {
 	}
 }
 
-func (rs *redisStore) conn() redis.Conn {
-	return rs.pool.Get()
+// conn fails with ErrBackend when redis is unreachable or the pool is exhausted,
+// instead of handing out a connection that fails every command
+func (rs *redisStore) conn() (redis.Conn, error) {
+	client := rs.pool.Get()
+	if err := client.Err(); err != nil {
+		client.Close()
+		return nil, backendError(fmt.Errorf("redis: could not connect to %s: %s", rs.addr, err))
+	}
+
+	return backendConn{client}, nil
+}
+
+// Ping checks redis is reachable, errors match ErrBackend
+func (rs *redisStore) Ping() error {
+	client, err := rs.conn()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.Do("PING")
+	return err
+}
+
+// Close releases every pooled connection, the store can't be used afterwards
+func (rs *redisStore) Close() error {
+	return rs.pool.Close()
+}
+
+// backendConn tags every command failure with ErrBackend, pool errors surface on Do as well
+type backendConn struct {
+	redis.Conn
+}
+
+func (bc backendConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := bc.Conn.Do(cmd, args...)
+	return reply, backendError(err)
 }
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// legacy layouts kept every item version of a namespace in one hash, first among items then next to them
+	redisLegacyVersionsSuffix = ":_versions"
+	redisVersionsHashSuffix   = "/versions"
+	// set once migrate ran, holds the layout version
+	redisLayoutKey     = "jarbas/layout"
+	redisLayoutVersion = 1
+)
+
+// migrate moves keys written by older versions to their current names, it runs once per database.
+// Only namespaces with saved item versions or given to WithMigration are touched.
+func (rs *redisStore) migrate() error {
+	client, err := rs.conn()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	layout, err := redis.Int(client.Do("GET", redisLayoutKey))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	if layout >= redisLayoutVersion {
+		return nil
+	}
+
+	namespaces := map[string]bool{}
+	for _, namespace := range rs.migrateNamespaces {
+		namespaces[namespace] = true
+	}
+
+	for _, suffix := range []string{redisLegacyVersionsSuffix, redisVersionsHashSuffix} {
+		err = scanKeys(client, "*"+suffix, func(key string) error {
+			namespace := strings.TrimSuffix(key, suffix)
+			migrated, err := migrateVersions(client, namespace, key)
+			if migrated {
+				namespaces[namespace] = true
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for namespace := range namespaces {
+		err := scanKeys(client, escapePattern(namespace)+":*", func(key string) error {
+			return migrateStack(client, namespaces, namespace, key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = client.Do("SET", redisLayoutKey, redisLayoutVersion)
+	return err
+}
+
+// migrateVersions moves a versions hash to 'ns/version:<id>' keys, expiring along with their items.
+// Items are plain strings, only hashes are versions.
+func migrateVersions(client redis.Conn, namespace string, key string) (bool, error) {
+	kind, err := redis.String(client.Do("TYPE", key))
+	if err != nil || kind != "hash" {
+		return false, err
+	}
+
+	versions, err := redis.StringMap(client.Do("HGETALL", key))
+	if err != nil {
+		return false, err
+	}
+
+	rn := &redisNamespace{namespace: namespace}
+	for id, version := range versions {
+		// versions of expired items used to stay behind
+		ttl, err := redis.Int64(client.Do("PTTL", rn.keyFor(id)))
+		if err != nil {
+			return false, err
+		}
+		if ttl == -2 {
+			continue
+		}
+
+		// saved since the upgrade, that version is newer
+		set, err := client.Do("SET", rn.versionKeyFor(id), version, "NX")
+		if err != nil {
+			return false, err
+		}
+
+		if set != nil && ttl > 0 {
+			if _, err := client.Do("PEXPIRE", rn.versionKeyFor(id), ttl); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	_, err = client.Do("DEL", key)
+	return true, err
+}
+
+// migrateStack moves 'ns:<name>' to 'ns/stack:<name>'. Items are plain strings, only lists are stacks.
+func migrateStack(client redis.Conn, namespaces map[string]bool, namespace string, key string) error {
+	if strings.Contains(key, "/stack:") || strings.Contains(key, "/set:") {
+		// current layout
+		return nil
+	}
+
+	// 'a:b:c' belongs to namespace 'a:b' when both 'a' and 'a:b' are known
+	for other := range namespaces {
+		if len(other) > len(namespace) && strings.HasPrefix(key, other+":") {
+			return nil
+		}
+	}
+
+	kind, err := redis.String(client.Do("TYPE", key))
+	if err != nil || kind != "list" {
+		return err
+	}
+
+	stack := strings.TrimPrefix(key, namespace+":")
+	target := (&redisNamespace{namespace: namespace}).stackKeyFor(stack)
+	renamed, err := redis.Bool(client.Do("RENAMENX", key, target))
+	if err != nil || renamed {
+		return err
+	}
+
+	// pushed to since the upgrade, older entries go in front one at a time
+	for {
+		_, err := redis.Bytes(client.Do("RPOPLPUSH", key, target))
+		if err == redis.ErrNil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// escapePattern quotes glob characters so s only matches itself in SCAN MATCH
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// scanKeys calls fn for every key matching pattern, fn may rename keys
+func scanKeys(client redis.Conn, pattern string, fn func(key string) error) error {
+	// collect first, renaming keys while scanning can return them twice
+	keys := []string{}
+	cursor := 0
+	for {
+		values, err := redis.Values(client.Do("SCAN", cursor, "MATCH", pattern))
+		if err != nil {
+			return err
+		}
+
+		var page []string
+		if _, err = redis.Scan(values, &cursor, &page); err != nil {
+			return err
+		}
+		keys = append(keys, page...)
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
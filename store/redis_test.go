@@ -1,9 +1,18 @@
 package store
 
-import "testing"
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
 
-func redisTestStore(t *testing.T) *redisStore {
-	rs, err := NewRedisStore()
+	"github.com/garyburd/redigo/redis"
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func redisTestStore(t *testing.T, opts ...redisOpt) *redisStore {
+	rs, err := NewRedisStore(opts...)
 
 	if err != nil {
 		t.Fatal(err)
@@ -15,3 +24,318 @@ func redisTestStore(t *testing.T) *redisStore {
 func TestRedis(t *testing.T) {
 	performStoreTest(t, redisTestStore(t))
 }
+
+func TestRedisCodec(t *testing.T) {
+	cc := &countingCodec{}
+	performStoreTest(t, redisTestStore(t, WithCodec(cc)))
+
+	assert.NotZero(t, cc.marshal)
+	assert.NotZero(t, cc.unmarshal)
+}
+
+func TestRedisIncrSkipsCodec(t *testing.T) {
+	cc := &countingCodec{}
+	rs := redisTestStore(t, WithCodec(cc))
+	defer rs.Close()
+
+	namespace := uuid.New()
+	value, err := rs.Namespace(namespace).Incr("counter", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), value)
+	assert.Zero(t, cc.marshal)
+
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	raw, err := redis.String(client.Do("GET", namespace+":counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, "5", raw)
+}
+
+func TestRedisUnreachable(t *testing.T) {
+	rs, err := NewRedisStore(WithAddr("localhost:1"))
+	assert.Nil(t, rs)
+	assert.True(t, errors.Is(err, ErrBackend))
+}
+
+func TestRedisPool(t *testing.T) {
+	rs := redisTestStore(t,
+		WithMaxIdle(1),
+		WithMaxActive(1),
+		WithIdleTimeout(time.Minute),
+		WithPoolWait(true),
+	)
+
+	assert.Equal(t, 1, rs.pool.MaxIdle)
+	assert.Equal(t, 1, rs.pool.MaxActive)
+	assert.Equal(t, time.Minute, rs.pool.IdleTimeout)
+
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second connection waits for the first one to be released
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		other, err := rs.conn()
+		if err == nil {
+			other.Close()
+		}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("pool handed out more than MaxActive connections")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pool never released the connection")
+	}
+}
+
+func TestRedisPoolExhausted(t *testing.T) {
+	rs := redisTestStore(t, WithMaxActive(1))
+	defer rs.Close()
+
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rs.Ping()
+	assert.True(t, errors.Is(err, ErrBackend))
+
+	_, err = rs.Namespace("exhausted").Exists("123")
+	assert.True(t, errors.Is(err, ErrBackend))
+
+	client.Close()
+	assert.Nil(t, rs.Ping())
+}
+
+func TestRedisClose(t *testing.T) {
+	rs := redisTestStore(t)
+	assert.Nil(t, rs.Ping())
+	assert.Nil(t, rs.Close())
+
+	err := rs.Ping()
+	assert.True(t, errors.Is(err, ErrBackend))
+
+	var stored stubItem
+	err = rs.Namespace("closed").FindByID("123", &stored)
+	assert.True(t, errors.Is(err, ErrBackend))
+}
+
+func TestRedisMigrateVersions(t *testing.T) {
+	rs := redisTestStore(t)
+	defer rs.Close()
+
+	namespace := uuid.New()
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// layout before versions moved out of the item keyspace
+	client.Do("SET", namespace+":123", `{"id":"123"}`)
+	client.Do("HSET", namespace+":_versions", "123", 7)
+	// then a hash next to items, versions of expired items stayed behind
+	other := uuid.New()
+	client.Do("SET", other+":123", `{"id":"123"}`, "PX", 60000)
+	client.Do("HSET", other+"/versions", "123", 3, "expired", 9)
+	client.Do("DEL", redisLayoutKey)
+
+	migrated := redisTestStore(t)
+	defer migrated.Close()
+
+	version, err := migrated.Namespace(namespace).Version("123")
+	assert.Nil(t, err)
+	assert.Equal(t, 7, version)
+
+	keys, err := migrated.Namespace(namespace).Keys()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"123"}, keys)
+
+	version, err = migrated.Namespace(other).Version("123")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version)
+
+	// expires along with the item
+	ttl, err := redis.Int64(client.Do("PTTL", other+"/version:123"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+
+	for _, key := range []string{namespace + ":_versions", other + "/versions", other + "/version:expired"} {
+		exists, err := redis.Bool(client.Do("EXISTS", key))
+		assert.Nil(t, err)
+		assert.False(t, exists, key)
+	}
+	client.Close()
+}
+
+func TestRedisMigrateStacks(t *testing.T) {
+	rs := redisTestStore(t)
+	defer rs.Close()
+
+	namespace := uuid.New()
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// layout before stacks moved out of the item keyspace
+	client.Do("SET", namespace+":123", `{"id":"123"}`)
+	client.Do("RPUSH", namespace+":log", `{"id":"1"}`, `{"id":"2"}`)
+	client.Do("RPUSH", namespace+":a:b", `{"id":"1"}`)
+	// pushed by an upgraded instance before we migrated
+	client.Do("RPUSH", namespace+"/stack:log", `{"id":"3"}`)
+	client.Do("DEL", redisLayoutKey)
+	client.Close()
+
+	// never saved an item, so it has to be named
+	migrated := redisTestStore(t, WithMigration(namespace))
+	defer migrated.Close()
+	ns := migrated.Namespace(namespace)
+
+	ids := []string{}
+	err = ns.All("log", func(out []byte) error {
+		var stored stubItem
+		if err := json.Unmarshal(out, &stored); err != nil {
+			return err
+		}
+		ids = append(ids, stored.ID)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, ids)
+
+	count, err := ns.Count("a:b")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	keys, err := ns.Keys()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"123"}, keys)
+}
+
+func TestRedisMigrateOwnedOnly(t *testing.T) {
+	rs := redisTestStore(t)
+	defer rs.Close()
+
+	namespace := "oauth2:" + uuid.New()
+	ns := rs.Namespace(namespace)
+	assert.Nil(t, ns.Push("q", &stubItem{ID: "1"}))
+
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// saved before versions moved to their own keys
+	client.Do("SET", namespace+":123", `{"id":"123"}`)
+	client.Do("HSET", namespace+"/versions", "123", 1)
+
+	foreign := "sidekiq:" + uuid.New()
+	client.Do("RPUSH", foreign, "job")
+	client.Do("DEL", redisLayoutKey)
+
+	migrated := redisTestStore(t)
+	defer migrated.Close()
+
+	count, err := migrated.Namespace(namespace).Count("q")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	kind, err := redis.String(client.Do("TYPE", foreign))
+	assert.Nil(t, err)
+	assert.Equal(t, "list", kind)
+
+	layout, err := redis.Int(client.Do("GET", redisLayoutKey))
+	assert.Nil(t, err)
+	assert.Equal(t, redisLayoutVersion, layout)
+}
+
+func TestRedisMigrateOnce(t *testing.T) {
+	rs := redisTestStore(t)
+	defer rs.Close()
+
+	namespace := uuid.New()
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// already migrated, legacy looking keys are left alone
+	client.Do("RPUSH", namespace+":log", `{"id":"1"}`)
+
+	migrated := redisTestStore(t, WithMigration(namespace))
+	defer migrated.Close()
+
+	count, err := migrated.Namespace(namespace).Count("log")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestRedisTransactionErrors(t *testing.T) {
+	rs := redisTestStore(t)
+	defer rs.Close()
+
+	namespace := uuid.New()
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// INCR fails inside MULTI, EXEC itself succeeds
+	client.Do("SET", namespace+"/version:123", "garbage")
+	client.Close()
+
+	err = rs.Namespace(namespace).Save(&stubItem{ID: "123"})
+	assert.True(t, errors.Is(err, ErrBackend))
+}
+
+func TestRedisSaveAllPartial(t *testing.T) {
+	rs := redisTestStore(t)
+	defer rs.Close()
+
+	namespace := uuid.New()
+	client, err := rs.conn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the version bump for "bad" fails
+	client.Do("SET", namespace+"/version:bad", "garbage")
+	client.Close()
+
+	ns := rs.Namespace(namespace)
+	err = ns.SaveAll([]Storable{&stubItem{ID: "good"}, &stubItem{ID: "bad"}, &stubItem{ID: "other"}})
+	if assert.IsType(t, &SaveAllError{}, err) {
+		assert.Equal(t, "bad", err.(*SaveAllError).ID)
+		assert.Equal(t, []string{"bad"}, err.(*SaveAllError).IDs)
+	}
+	assert.True(t, errors.Is(err, ErrBackend))
+
+	for _, id := range []string{"good", "other"} {
+		version, err := ns.Version(id)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, version)
+	}
+}
+
+func TestRedisMillis(t *testing.T) {
+	assert.Equal(t, int64(1), redisMillis(time.Microsecond))
+	assert.Equal(t, int64(1), redisMillis(time.Millisecond))
+	assert.Equal(t, int64(2), redisMillis(1500*time.Microsecond))
+}
@@ -2,14 +2,81 @@ package store
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
 var (
-	NeverExpire     = time.Time{}
-	ErrItemNotFound = errors.New("not found")
+	NeverExpire        = time.Time{}
+	ErrItemNotFound    = errors.New("not found")
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrSerialization is matched by errors.Is when an item can't be marshalled or unmarshalled
+	ErrSerialization = errors.New("serialization failed")
+	// ErrBackend is matched by errors.Is when the underlying storage (connection, file) fails
+	ErrBackend = errors.New("backend failed")
 )
 
+// StoreError tags a codec or backend failure with its Kind (ErrSerialization, ErrBackend)
+type StoreError struct {
+	Kind error
+	Err  error
+}
+
+func (se *StoreError) Error() string {
+	return fmt.Sprintf("%s: %s", se.Kind, se.Err)
+}
+
+func (se *StoreError) Unwrap() error {
+	return se.Err
+}
+
+func (se *StoreError) Is(target error) bool {
+	return target == se.Kind
+}
+
+func serializationError(err error) error {
+	return wrapError(ErrSerialization, err)
+}
+
+func backendError(err error) error {
+	return wrapError(ErrBackend, err)
+}
+
+// wrapError leaves nil, our own sentinels and already tagged errors untouched
+func wrapError(kind error, err error) error {
+	switch err.(type) {
+	case nil, *StoreError, *SaveAllError:
+		return err
+	}
+
+	if err == ErrItemNotFound || err == ErrVersionConflict {
+		return err
+	}
+
+	return &StoreError{Kind: kind, Err: err}
+}
+
+// SaveAllError reports the item that prevented SaveAll from persisting the batch
+type SaveAllError struct {
+	ID string
+	// IDs is set when the batch was only partially saved, listing every item that failed.
+	// Redis can't roll back commands that fail inside a transaction.
+	IDs []string
+	Err error
+}
+
+func (se *SaveAllError) Error() string {
+	if len(se.IDs) > 0 {
+		return fmt.Sprintf("could not save %q, other items saved: %s", se.IDs, se.Err)
+	}
+
+	return fmt.Sprintf("could not save %q, batch discarded: %s", se.ID, se.Err)
+}
+
+func (se *SaveAllError) Unwrap() error {
+	return se.Err
+}
+
 type Storable interface {
 	StoreID() string
 	// StoreExpires is a hint for stores on how durable this information is
@@ -19,12 +86,41 @@ type Storable interface {
 
 type Namespace interface {
 	FindByID(id string, out interface{}) error
+	Exists(id string) (bool, error)
 	Save(item Storable) error
+	// Version returns the current version of 'id', 0 when it doesn't exist
+	Version(id string) (int, error)
+	// SaveIfVersion only saves when the stored version matches expectedVersion,
+	// returning ErrVersionConflict otherwise
+	SaveIfVersion(item Storable, expectedVersion int) error
+	// SaveAll saves all items or none of them, except on redis where a *SaveAllError lists the failed ones
+	SaveAll(items []Storable) error
 	Delete(id string) error
+	Keys() ([]string, error)
+	// Incr atomically adds delta to the counter 'id', returning the new value.
+	// Redis keeps counters as plain numbers whatever the codec, FindByID reads them back with JSONCodec.
+	Incr(id string, delta int64) (int64, error)
 
 	Push(stack string, item Storable) error
+	// PushWithTTL pushes an entry that is dropped once ttl elapses
+	PushWithTTL(stack string, item Storable, ttl time.Duration) error
 	Pop(stack string, out interface{}) error
+	// BPop waits up to timeout for an entry to pop, 0 or less waits forever.
+	// Returns ErrItemNotFound on timeout.
+	BPop(stack string, timeout time.Duration, out interface{}) error
+	// Peek reads the entry Pop would return, leaving it in the stack
+	Peek(stack string, out interface{}) error
+	// All calls cb with every entry, an unknown stack is empty
 	All(stack string, cb func(out []byte) error) error
+	// Count of live entries, an unknown stack is empty
+	Count(stack string) (int, error)
+
+	// SetAdd returns true when member wasn't in set yet
+	SetAdd(set string, member string) (bool, error)
+	// SetRemove returns true when member was in set
+	SetRemove(set string, member string) (bool, error)
+	// SetMembers lists members in no particular order, an unknown set is empty
+	SetMembers(set string) ([]string, error)
 }
 
 type Store interface {
@@ -2,6 +2,8 @@ package store
 
 import (
 	"encoding/json"
+	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +28,36 @@ func (si *stubItem) StoreExpires() time.Time {
 	return si.expires
 }
 
+// brokenItem fails to marshal
+type brokenItem struct {
+	ID   string      `json:"id"`
+	Fail func() bool `json:"fail"`
+}
+
+func (bi *brokenItem) StoreID() string {
+	return bi.ID
+}
+
+func (bi *brokenItem) StoreExpires() time.Time {
+	return NeverExpire
+}
+
+// countingCodec proves stores route serialization through their codec
+type countingCodec struct {
+	marshal   int64
+	unmarshal int64
+}
+
+func (cc *countingCodec) Marshal(v interface{}) ([]byte, error) {
+	atomic.AddInt64(&cc.marshal, 1)
+	return JSONCodec.Marshal(v)
+}
+
+func (cc *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	atomic.AddInt64(&cc.unmarshal, 1)
+	return JSONCodec.Unmarshal(data, v)
+}
+
 func performStoreTest(t *testing.T, s Store) {
 	t.Run("saveLoad", func(t *testing.T) {
 		namespace := s.Namespace(uuid.New())
@@ -55,6 +87,93 @@ func performStoreTest(t *testing.T, s Store) {
 		assert.Equal(t, someNumber, stored.SomeNumber)
 	})
 
+	t.Run("exists", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		id := "123"
+		exists, err := namespace.Exists(id)
+		assert.Nil(t, err)
+		assert.False(t, exists)
+
+		if err := namespace.Save(&stubItem{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+
+		exists, err = namespace.Exists(id)
+		assert.Nil(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		keys, err := namespace.Keys()
+		assert.Nil(t, err)
+		assert.Empty(t, keys)
+
+		ids := []string{"123", "456", "789"}
+		for _, id := range ids {
+			if err := namespace.Save(&stubItem{ID: id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		keys, err = namespace.Keys()
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, ids, keys)
+	})
+
+	t.Run("incr", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		id := "counter"
+		value, err := namespace.Incr(id, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(5), value)
+
+		value, err = namespace.Incr(id, -2)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), value)
+
+		var stored int64
+		err = namespace.FindByID(id, &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), stored)
+	})
+
+	t.Run("saveIfVersion", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		id := "123"
+		version, err := namespace.Version(id)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, version)
+
+		err = namespace.SaveIfVersion(&stubItem{ID: id, Thing: "first"}, 0)
+		assert.Nil(t, err)
+
+		version, err = namespace.Version(id)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, version)
+
+		// someone else got there first
+		err = namespace.Save(&stubItem{ID: id, Thing: "second"})
+		assert.Nil(t, err)
+
+		err = namespace.SaveIfVersion(&stubItem{ID: id, Thing: "stale"}, version)
+		assert.Equal(t, ErrVersionConflict, err)
+
+		var stored stubItem
+		err = namespace.FindByID(id, &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, "second", stored.Thing)
+
+		version, err = namespace.Version(id)
+		assert.Nil(t, err)
+		err = namespace.SaveIfVersion(&stubItem{ID: id, Thing: "third"}, version)
+		assert.Nil(t, err)
+	})
+
 	t.Run("saveDelete", func(t *testing.T) {
 		namespace := s.Namespace(uuid.New())
 
@@ -119,16 +238,447 @@ func performStoreTest(t *testing.T, s Store) {
 		assert.Equal(t, id, stored.ID)
 	})
 
+	t.Run("bpop", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		stack := uuid.New()
+		var stored stubItem
+
+		// redis rounds timeouts up to a second
+		start := time.Now()
+		err := namespace.BPop(stack, 10*time.Millisecond, &stored)
+		assert.Equal(t, ErrItemNotFound, err)
+		assert.True(t, time.Since(start) >= 10*time.Millisecond)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			namespace.Push(stack, &stubItem{ID: "123"})
+		}()
+
+		err = namespace.BPop(stack, 5*time.Second, &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, "123", stored.ID)
+
+		count, err := namespace.Count(stack)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, count)
+	})
+	t.Run("bpop negative timeout", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			namespace.Push("waiting", &stubItem{ID: "123"})
+		}()
+
+		// waits forever, like 0
+		var stored stubItem
+		err := namespace.BPop("waiting", -time.Second, &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, "123", stored.ID)
+	})
+
+	t.Run("sets", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		members, err := namespace.SetMembers("links")
+		assert.Nil(t, err)
+		assert.Empty(t, members)
+
+		added, err := namespace.SetAdd("links", "https://example.com/a")
+		assert.Nil(t, err)
+		assert.True(t, added)
+
+		added, err = namespace.SetAdd("links", "https://example.com/a")
+		assert.Nil(t, err)
+		assert.False(t, added)
+
+		added, err = namespace.SetAdd("links", "https://example.com/b")
+		assert.Nil(t, err)
+		assert.True(t, added)
+
+		// sets don't clash with items or stacks
+		err = namespace.Save(&stubItem{ID: "links"})
+		assert.Nil(t, err)
+		err = namespace.Push("links", &stubItem{ID: "pushed"})
+		assert.Nil(t, err)
+
+		members, err = namespace.SetMembers("links")
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []string{"https://example.com/a", "https://example.com/b"}, members)
+
+		removed, err := namespace.SetRemove("links", "https://example.com/a")
+		assert.Nil(t, err)
+		assert.True(t, removed)
+
+		removed, err = namespace.SetRemove("links", "https://example.com/a")
+		assert.Nil(t, err)
+		assert.False(t, removed)
+
+		removed, err = namespace.SetRemove("unknown", "https://example.com/a")
+		assert.Nil(t, err)
+		assert.False(t, removed)
+
+		members, err = namespace.SetMembers("links")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"https://example.com/b"}, members)
+	})
+
+	t.Run("version after expiry", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		err := namespace.Save(&stubItem{ID: "123", expires: time.Now().Add(20 * time.Millisecond)})
+		assert.Nil(t, err)
+		err = namespace.Save(&stubItem{ID: "123", expires: time.Now().Add(20 * time.Millisecond)})
+		assert.Nil(t, err)
+
+		version, err := namespace.Version("123")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, version)
+
+		time.Sleep(50 * time.Millisecond)
+
+		version, err = namespace.Version("123")
+		assert.Nil(t, err)
+		assert.Equal(t, 0, version)
+
+		// starts over, like a brand new item
+		err = namespace.Save(&stubItem{ID: "123"})
+		assert.Nil(t, err)
+
+		version, err = namespace.Version("123")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, version)
+	})
+
+	t.Run("reserved ids", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		// used to be where redis kept versions
+		err := namespace.Save(&stubItem{ID: "_versions", Thing: "item"})
+		assert.Nil(t, err)
+
+		err = namespace.Save(&stubItem{ID: "123"})
+		assert.Nil(t, err)
+
+		version, err := namespace.Version("123")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, version)
+
+		err = namespace.SaveIfVersion(&stubItem{ID: "123"}, version)
+		assert.Nil(t, err)
+
+		keys, err := namespace.Keys()
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []string{"_versions", "123"}, keys)
+
+		var stored stubItem
+		err = namespace.FindByID("_versions", &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, "item", stored.Thing)
+	})
+
+	t.Run("peek", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		stack := uuid.New()
+		var stored stubItem
+		err := namespace.Peek(stack, &stored)
+		assert.Equal(t, ErrItemNotFound, err)
+
+		for _, id := range []string{"123", "456"} {
+			err = namespace.Push(stack, &stubItem{ID: id})
+			assert.Nil(t, err)
+		}
+
+		// peeking twice returns the same head
+		for i := 0; i < 2; i++ {
+			err = namespace.Peek(stack, &stored)
+			assert.Nil(t, err)
+			assert.Equal(t, "123", stored.ID)
+		}
+
+		count, err := namespace.Count(stack)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+
+		err = namespace.Pop(stack, &stored)
+		assert.Nil(t, err)
+		err = namespace.Peek(stack, &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, "456", stored.ID)
+
+		err = namespace.Pop(stack, &stored)
+		assert.Nil(t, err)
+		err = namespace.Peek(stack, &stored)
+		assert.Equal(t, ErrItemNotFound, err)
+	})
+
 	t.Run("all", func(t *testing.T) {
 		namespace := s.Namespace(uuid.New())
 
 		stack := uuid.New()
+		ids := []string{"123", "456", "789"}
+		for _, id := range ids {
+			err := namespace.Push(stack, &stubItem{ID: id})
+			assert.Nil(t, err)
+		}
+
+		seen := []string{}
+		err := namespace.All(stack, func(out []byte) error {
+			var stored stubItem
+			if err := json.Unmarshal(out, &stored); err != nil {
+				return err
+			}
+			seen = append(seen, stored.ID)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, ids, seen)
+
+		// returning an error stops the iteration
+		stop := errors.New("stop")
+		calls := 0
+		err = namespace.All(stack, func(out []byte) error {
+			calls++
+			return stop
+		})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, 1, calls)
+	})
+	t.Run("all unknown stack", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		// emptied stacks look the same as ones never pushed to
+		assert.Nil(t, namespace.Push("emptied", &stubItem{ID: "123"}))
+		assert.Nil(t, namespace.Pop("emptied", &stubItem{}))
+
+		for _, stack := range []string{"unknown", "emptied"} {
+			calls := 0
+			err := namespace.All(stack, func(out []byte) error {
+				calls++
+				return nil
+			})
+			assert.Nil(t, err)
+			assert.Equal(t, 0, calls)
+
+			count, err := namespace.Count(stack)
+			assert.Nil(t, err)
+			assert.Equal(t, 0, count)
+		}
+	})
+	t.Run("expires", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
 		id := "123"
 		si := &stubItem{
-			ID: id,
+			ID:      id,
+			expires: time.Now().Add(50 * time.Millisecond),
 		}
 
-		err := namespace.Push(stack, si)
+		if err := namespace.Save(si); err != nil {
+			t.Fatal(err)
+		}
+
+		var stored stubItem
+		err := namespace.FindByID(id, &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, id, stored.ID)
+
+		time.Sleep(100 * time.Millisecond)
+
+		err = namespace.FindByID(id, &stored)
+		assert.Equal(t, ErrItemNotFound, err)
+	})
+	t.Run("count", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		stack := uuid.New()
+		count, err := namespace.Count(stack)
 		assert.Nil(t, err)
+		assert.Equal(t, 0, count)
+
+		for _, id := range []string{"123", "456"} {
+			err = namespace.Push(stack, &stubItem{ID: id})
+			assert.Nil(t, err)
+		}
+
+		count, err = namespace.Count(stack)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count)
+
+		var stored stubItem
+		err = namespace.Pop(stack, &stored)
+		assert.Nil(t, err)
+
+		count, err = namespace.Count(stack)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+	})
+	t.Run("pushWithTTL", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		stack := uuid.New()
+		err := namespace.PushWithTTL(stack, &stubItem{ID: "123"}, 50*time.Millisecond)
+		assert.Nil(t, err)
+
+		count, err := namespace.Count(stack)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+
+		time.Sleep(100 * time.Millisecond)
+
+		var stored stubItem
+		err = namespace.Pop(stack, &stored)
+		assert.Equal(t, ErrItemNotFound, err)
+	})
+	t.Run("pushWithoutTTL", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		stack := uuid.New()
+		err := namespace.Push(stack, &stubItem{ID: "123"})
+		assert.Nil(t, err)
+
+		// 0 & negative never expire, nor drop what is already there
+		for _, ttl := range []time.Duration{0, -time.Second} {
+			err = namespace.PushWithTTL(stack, &stubItem{ID: "456"}, ttl)
+			assert.Nil(t, err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+
+		count, err := namespace.Count(stack)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, count)
+	})
+	t.Run("saveAll", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		ids := []string{"123", "456", "789"}
+		items := []Storable{}
+		for _, id := range ids {
+			items = append(items, &stubItem{ID: id})
+		}
+
+		err := namespace.SaveAll(items)
+		assert.Nil(t, err)
+
+		for _, id := range ids {
+			var stored stubItem
+			err = namespace.FindByID(id, &stored)
+			assert.Nil(t, err)
+			assert.Equal(t, id, stored.ID)
+		}
+
+		// nothing persists when one of the items can't be marshalled
+		err = namespace.SaveAll([]Storable{&stubItem{ID: "good"}, &brokenItem{ID: "bad"}})
+		if assert.IsType(t, &SaveAllError{}, err) {
+			assert.Equal(t, "bad", err.(*SaveAllError).ID)
+		}
+		assert.True(t, errors.Is(err, ErrSerialization))
+
+		exists, err := namespace.Exists("good")
+		assert.Nil(t, err)
+		assert.False(t, exists)
+	})
+	t.Run("typed errors", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		err := namespace.Save(&brokenItem{ID: "bad"})
+		assert.True(t, errors.Is(err, ErrSerialization))
+		assert.False(t, errors.Is(err, ErrBackend))
+
+		err = namespace.Push("stack", &brokenItem{ID: "bad"})
+		assert.True(t, errors.Is(err, ErrSerialization))
+
+		err = namespace.Save(&stubItem{ID: "123", Thing: "thing"})
+		assert.Nil(t, err)
+
+		var wrongType int
+		err = namespace.FindByID("123", &wrongType)
+		assert.True(t, errors.Is(err, ErrSerialization))
+
+		// the codec error is still reachable
+		var typeErr *json.UnmarshalTypeError
+		assert.True(t, errors.As(err, &typeErr))
+
+		// sentinels are returned as is
+		err = namespace.FindByID("gone", &wrongType)
+		assert.Equal(t, ErrItemNotFound, err)
+	})
+	t.Run("stacks and items", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		err := namespace.Push("queue", &stubItem{ID: "pushed"})
+		assert.Nil(t, err)
+
+		// same name, different keyspace
+		for _, id := range []string{"queue", "_stack_queue"} {
+			err = namespace.Save(&stubItem{ID: id, Thing: "saved"})
+			assert.Nil(t, err)
+		}
+
+		keys, err := namespace.Keys()
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []string{"queue", "_stack_queue"}, keys)
+
+		count, err := namespace.Count("queue")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+
+		var stored stubItem
+		err = namespace.Pop("queue", &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, "pushed", stored.ID)
+
+		err = namespace.FindByID("queue", &stored)
+		assert.Nil(t, err)
+		assert.Equal(t, "saved", stored.Thing)
+
+		// deleting the item leaves stacks alone
+		err = namespace.Push("queue", &stubItem{ID: "again"})
+		assert.Nil(t, err)
+		err = namespace.Delete("queue")
+		assert.Nil(t, err)
+
+		count, err = namespace.Count("queue")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+	})
+	t.Run("all in chunks", func(t *testing.T) {
+		namespace := s.Namespace(uuid.New())
+
+		// spans a few chunks on every store
+		total := 250
+		for i := 0; i < total; i++ {
+			err := namespace.Push("big", &stubItem{ID: "item", SomeNumber: i})
+			assert.Nil(t, err)
+		}
+
+		seen := 0
+		err := namespace.All("big", func(out []byte) error {
+			var stored stubItem
+			if err := json.Unmarshal(out, &stored); err != nil {
+				return err
+			}
+
+			assert.Equal(t, seen, stored.SomeNumber)
+			seen++
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, total, seen)
+
+		stop := errors.New("stop")
+		seen = 0
+		err = namespace.All("big", func(out []byte) error {
+			seen++
+			if seen == 150 {
+				return stop
+			}
+			return nil
+		})
+		assert.Equal(t, stop, err)
+		assert.Equal(t, 150, seen)
 	})
 }